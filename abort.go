@@ -0,0 +1,83 @@
+package swish
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Abort cancels every request in flight for the payment request or refund with instructionUUID, e.g. when the user
+// aborts a payment. This covers creating it and getting or polling its status. The aborted calls fail with
+// context.Canceled. Calls started after Abort are not affected.
+//
+// Status calls are found by the identifier at the end of the location. With V2 that is the InstructionUUID, but with
+// V1 Swish assigns it, so Abort with the InstructionUUID only cancels the create, and status calls must be aborted with
+// the identifier at the end of the Location instead.
+func (s *Swish) Abort(instructionUUID string) {
+	key := instructionKey(instructionUUID)
+
+	s.inflightMu.Lock()
+	cancels := s.inflight[key]
+	delete(s.inflight, key)
+	s.inflightMu.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// track returns a context that is canceled by Abort with instructionUUID, and a function that must be called when
+// the operation is done to release it. Nothing is tracked for an empty instructionUUID.
+func (s *Swish) track(ctx context.Context, instructionUUID string) (context.Context, func()) {
+	key := instructionKey(instructionUUID)
+	if key == "" {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	s.inflightMu.Lock()
+	if s.inflight == nil {
+		s.inflight = make(map[string]map[uint64]context.CancelFunc)
+	}
+
+	if s.inflight[key] == nil {
+		s.inflight[key] = make(map[uint64]context.CancelFunc)
+	}
+
+	s.inflightSeq++
+	id := s.inflightSeq
+	s.inflight[key][id] = cancel
+	s.inflightMu.Unlock()
+
+	return ctx, func() {
+		s.inflightMu.Lock()
+		delete(s.inflight[key], id)
+		if len(s.inflight[key]) == 0 {
+			delete(s.inflight, key)
+		}
+		s.inflightMu.Unlock()
+
+		cancel()
+	}
+}
+
+// trackLocation works as track with the identifier at the end of location, which is only the InstructionUUID with V2
+func (s *Swish) trackLocation(ctx context.Context, location string) (context.Context, func()) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return ctx, func() {}
+	}
+
+	return s.track(ctx, path.Base(u.Path))
+}
+
+// instructionKey returns instructionUUID in the form Swish uses in locations, so that dashed and plain identifiers match
+func instructionKey(instructionUUID string) string {
+	if instructionUUID == "" || instructionUUID == "." || instructionUUID == "/" {
+		return ""
+	}
+
+	return strings.ToUpper(strings.Replace(instructionUUID, "-", "", -1))
+}
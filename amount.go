@@ -0,0 +1,86 @@
+package swish
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// AmountRounding is how amounts with more than two decimals are rounded before they are sent
+type AmountRounding int
+
+const (
+	// RoundNone rejects amounts with more than two decimals
+	RoundNone AmountRounding = iota
+
+	// RoundHalfUp rounds to the nearest two decimals, and up if the amount is halfway, e.g. 100.005 to 100.01
+	RoundHalfUp
+
+	// RoundDown truncates to two decimals, e.g. 100.009 to 100.00
+	RoundDown
+)
+
+// normalizeAmount rounds an amount with more than two decimals according to rounding. Other amounts are returned
+// unchanged and left for Swish to validate.
+func normalizeAmount(amount string, rounding AmountRounding) (string, error) {
+	i := strings.IndexByte(amount, '.')
+	if i < 0 || len(amount)-i-1 <= 2 {
+		return amount, nil
+	}
+
+	whole, decimals := amount[:i], amount[i+1:]
+	for _, r := range whole + decimals {
+		if r < '0' || r > '9' {
+			return amount, nil
+		}
+	}
+
+	if rounding != RoundDown && rounding != RoundHalfUp {
+		return "", fmt.Errorf("amount %q has more than two decimals", amount)
+	}
+
+	units, err := parseMinorUnits(whole + "." + decimals[:2])
+	if err != nil {
+		return "", err
+	}
+
+	if rounding == RoundHalfUp && decimals[2] >= '5' {
+		units++
+	}
+
+	return formatMinorUnits(units), nil
+}
+
+// parseMinorUnits converts an amount such as "100.01" to minor units, 10001, without the rounding errors of floats
+func parseMinorUnits(amount string) (int64, error) {
+	whole, fraction := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		whole, fraction = amount[:i], amount[i+1:]
+	}
+
+	if whole == "" || len(fraction) > 2 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+
+	return units, nil
+}
+
+// floatMinorUnits converts an amount decoded from Swish to minor units
+func floatMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// formatMinorUnits formats minor units as an amount with two decimals, e.g. 10001 as "100.01"
+func formatMinorUnits(units int64) string {
+	return fmt.Sprintf("%d.%02d", units/100, units%100)
+}
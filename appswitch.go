@@ -0,0 +1,54 @@
+package swish
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Platform is the mobile platform that opens the Swish app
+type Platform int
+
+const (
+	// IOS opens the Swish app through a universal link
+	IOS Platform = iota
+
+	// Android opens the Swish app through the swish:// scheme
+	Android
+)
+
+const (
+	universalLinkURL = "https://app.swish.nu/1/p/sw/"
+	appSchemeURL     = "swish://paymentrequest"
+)
+
+// AppSwitchPayload returns the URL that opens the Swish app for the PaymentRequestToken of an m-commerce payment
+// request. The callbackURL is where the Swish app returns to when the payment is done, usually a scheme of the merchant
+// app, and it must be absolute.
+func AppSwitchPayload(token, callbackURL string, platform Platform) (string, error) {
+	if token == "" {
+		return "", errors.New("token is required")
+	}
+
+	callback, err := url.Parse(callbackURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid callback url: %w", err)
+	}
+
+	if !callback.IsAbs() {
+		return "", fmt.Errorf("callback url %q must be absolute", callbackURL)
+	}
+
+	query := url.Values{}
+	query.Set("token", token)
+	query.Set("callbackurl", callback.String())
+
+	switch platform {
+	case IOS:
+		return universalLinkURL + "?" + query.Encode(), nil
+	case Android:
+		return appSchemeURL + "?" + query.Encode(), nil
+	}
+
+	return "", fmt.Errorf("unknown platform %d", platform)
+}
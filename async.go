@@ -0,0 +1,25 @@
+package swish
+
+import "context"
+
+// CreatePaymentRequestAsync creates a payment request in the background. Exactly one of the returned channels receives
+// a value, the result or the error. The channels are buffered so the request finishes even if nobody receives. Calling
+// the returned cancel func aborts the request, and it must be called to release the resources once the request is no
+// longer needed.
+func (s *Swish) CreatePaymentRequestAsync(ctx context.Context, opts CreatePaymentRequestOptions, reqOpts ...RequestOption) (<-chan createPaymentRequestResponse, <-chan error, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan createPaymentRequestResponse, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		result, err := s.CreatePaymentRequest(ctx, opts, reqOpts...)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		results <- result
+	}()
+
+	return results, errs, cancel
+}
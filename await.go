@@ -0,0 +1,53 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAppSwitchRequired is matched by an AppSwitchError
+var ErrAppSwitchRequired = errors.New("the payer must open the Swish app with the payment request token")
+
+// AppSwitchError is returned by CreateAndAwait for an m-commerce payment request when WithAppSwitch is given. The
+// payer can only pay once the Swish app has been opened with the token, so there is nothing to wait for until the
+// caller has done so.
+type AppSwitchError struct {
+	// Payment is the created payment request, with the PaymentRequestToken to open the app with and the Location to
+	// wait for afterwards with WaitForFinalStatus
+	Payment createPaymentRequestResponse
+}
+
+func (e *AppSwitchError) Error() string {
+	return ErrAppSwitchRequired.Error()
+}
+
+func (e *AppSwitchError) Is(target error) bool {
+	return target == ErrAppSwitchRequired
+}
+
+// CreateAndAwait creates a payment request and polls its status every interval until it is final, and returns the
+// final status. An m-commerce payment request, without PayerAlias, is polled as well, e.g. while a QR code is shown.
+// With WithAppSwitch it instead returns right after it is created with a CREATED status and an AppSwitchError holding
+// the token, so that the caller can switch to the app before the payer can pay.
+func (s *Swish) CreateAndAwait(ctx context.Context, opts CreatePaymentRequestOptions, interval time.Duration, reqOpts ...RequestOption) (statusResponse, error) {
+	err := checkInterval(interval)
+	if err != nil {
+		return statusResponse{}, err
+	}
+
+	payment, err := s.CreatePaymentRequest(ctx, opts, reqOpts...)
+	if err != nil {
+		return statusResponse{}, err
+	}
+
+	if newRequestOptions(reqOpts).appSwitch && payment.Flow == MCommerce {
+		return statusResponse{
+			InstructionUUID: payment.InstructionUUID,
+			Status:          string(StatusCreated),
+			ResponseInfo:    payment.ResponseInfo,
+		}, &AppSwitchError{Payment: payment}
+	}
+
+	return s.WaitForFinalStatus(ctx, payment.Location, interval)
+}
@@ -0,0 +1,200 @@
+package swish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sync"
+)
+
+// forEach calls fn for every index up to n with at most concurrency calls running at the same time. Indexes that are
+// not started before ctx is done get the context error.
+func forEach(ctx context.Context, n, concurrency int, fn func(i int) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	errs := make([]error, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// BatchItem is the outcome of one input of a batch operation
+type BatchItem struct {
+	// Index is the position of the input in the batch
+	Index int
+	// Status is the status of the payment request or refund, it may be partially filled if Err is set
+	Status statusResponse
+	// Location is the location of the created refund, it is only set by CreateRefundBatch
+	Location string
+	// Err is the reason the input failed, it is nil on success
+	Err error
+}
+
+// BatchResult holds the outcome of every input of a batch operation, in the order of the inputs
+type BatchResult []BatchItem
+
+// Failures returns the items that failed
+func (r BatchResult) Failures() BatchResult {
+	var failures BatchResult
+	for _, item := range r {
+		if item.Err != nil {
+			failures = append(failures, item)
+		}
+	}
+
+	return failures
+}
+
+// Successes returns the items that succeeded
+func (r BatchResult) Successes() BatchResult {
+	var successes BatchResult
+	for _, item := range r {
+		if item.Err == nil {
+			successes = append(successes, item)
+		}
+	}
+
+	return successes
+}
+
+// newBatchResult pairs the statuses and errors of a batch with their index
+func newBatchResult(statuses []statusResponse, errs []error) BatchResult {
+	result := make(BatchResult, len(statuses))
+	for i := range statuses {
+		result[i] = BatchItem{Index: i, Status: statuses[i], Err: errs[i]}
+	}
+
+	return result
+}
+
+// StatusBatch gets the status of the payment requests or refunds at locations, with at most concurrency requests
+// running at the same time.
+func (s *Swish) StatusBatch(ctx context.Context, locations []string, concurrency int) BatchResult {
+	statuses := make([]statusResponse, len(locations))
+	errs := forEach(ctx, len(locations), concurrency, func(i int) (err error) {
+		statuses[i], err = s.Status(ctx, locations[i])
+		return
+	})
+
+	return newBatchResult(statuses, errs)
+}
+
+// CreateRefundBatch creates the refunds in opts, with at most concurrency requests running at the same time. Only the
+// InstructionUUID and the ResponseInfo of the Status of an item are set, get the status of the refund from its
+// Location. With V1 the InstructionUUID is the one that Swish assigned.
+func (s *Swish) CreateRefundBatch(ctx context.Context, opts []CreateRefundOptions, concurrency int) BatchResult {
+	refunds := make([]createRefundResponse, len(opts))
+	errs := forEach(ctx, len(opts), concurrency, func(i int) (err error) {
+		refunds[i], err = s.CreateRefund(ctx, opts[i])
+		return
+	})
+
+	result := make(BatchResult, len(opts))
+	for i, refund := range refunds {
+		id := opts[i].InstructionUUID
+		if u, err := url.Parse(refund.Location); err == nil && refund.Location != "" {
+			id = path.Base(u.Path)
+		}
+
+		result[i] = BatchItem{
+			Index:    i,
+			Status:   statusResponse{InstructionUUID: id, ResponseInfo: refund.ResponseInfo},
+			Location: refund.Location,
+			Err:      errs[i],
+		}
+	}
+
+	return result
+}
+
+// CancelBatch cancels the payment requests at locations that are still CREATED, with at most concurrency requests
+// running at the same time. Payment requests that already reached another status are skipped without error.
+func (s *Swish) CancelBatch(ctx context.Context, locations []string, concurrency int) BatchResult {
+	statuses := make([]statusResponse, len(locations))
+	errs := forEach(ctx, len(locations), concurrency, func(i int) (err error) {
+		statuses[i], err = s.Status(ctx, locations[i])
+		if err != nil || statuses[i].Status != "CREATED" {
+			return
+		}
+
+		statuses[i], err = s.CancelPayment(ctx, locations[i])
+		return
+	})
+
+	return newBatchResult(statuses, errs)
+}
+
+// ReconcileStatuses gets the status of the payment requests with the given InstructionUUIDs, e.g. to catch up on
+// callbacks that were missed while the callback endpoint was down. At most concurrency requests run at the same time.
+// The statuses and errors are in the order of ids, an invalid id gets an error without contacting Swish.
+func (s *Swish) ReconcileStatuses(ctx context.Context, ids []string, concurrency int) ([]statusResponse, []error) {
+	statuses := make([]statusResponse, len(ids))
+	errs := forEach(ctx, len(ids), concurrency, func(i int) error {
+		id, err := formatInstructionUUID(ids[i])
+		if err != nil {
+			return err
+		}
+
+		statuses[i], err = s.Status(ctx, getPaymentRequest.url(s.URL, id))
+		return err
+	})
+
+	return statuses, errs
+}
+
+// ValidateBatch checks every payment request of a batch as CreatePaymentRequest does before sending, without contacting
+// Swish, e.g. to reject the whole batch if any is invalid. The fields are also checked against the required fields and
+// constraints of OptionsSchema, and a PayeeAlias must be set or taken from the certificate. The errors are in the order
+// of opts, and nil for a valid payment request. An InstructionUUID that is malformed or used more than once in the
+// batch is also an error.
+func (s *Swish) ValidateBatch(opts []CreatePaymentRequestOptions) []error {
+	required := append([]string{"payeeAlias"}, requiredPaymentFields...)
+	errs := make([]error, len(opts))
+	seen := make(map[string]int)
+	for i, o := range opts {
+		prepared, err := s.preparePaymentRequest(context.Background(), o)
+		if err == nil {
+			err = validateFields(prepared, required)
+		}
+
+		if err != nil || o.InstructionUUID == "" {
+			errs[i] = err
+			continue
+		}
+
+		key, err := formatInstructionUUID(o.InstructionUUID)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if first, ok := seen[key]; ok {
+			errs[i] = fmt.Errorf("%w: %s is also used by index %d", ErrDuplicateInstruction, o.InstructionUUID, first)
+			continue
+		}
+
+		seen[key] = i
+	}
+
+	return errs
+}
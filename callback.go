@@ -0,0 +1,213 @@
+package swish
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CallbackURLCheck is what happens when a callback URL that Swish can not reach is used against production
+type CallbackURLCheck int
+
+const (
+	// CallbackURLWarn logs a warning and sends the request
+	CallbackURLWarn CallbackURLCheck = iota
+
+	// CallbackURLError returns an error without sending the request
+	CallbackURLError
+
+	// CallbackURLOff disables the check, e.g. when callbacks are tunneled to a private address
+	CallbackURLOff
+)
+
+// privateNetworks are the address ranges that Swish can not reach
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "::1/128", "fc00::/7", "fe80::/10"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+
+	return networks
+}()
+
+// isPrivateHost reports whether host is localhost or an address on a private network
+func isPrivateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCallbackURL looks for callback URLs that Swish can not reach in production, which is usually test
+// configuration that was copied to production.
+func (s *Swish) checkCallbackURL(callbackURL string) error {
+	if s.test || s.urlCheck == CallbackURLOff {
+		return nil
+	}
+
+	u, err := url.Parse(callbackURL)
+	if err != nil || !isPrivateHost(u.Hostname()) {
+		return nil
+	}
+
+	if s.urlCheck == CallbackURLError {
+		return fmt.Errorf("callback url %q is not reachable by Swish in production", callbackURL)
+	}
+
+	s.logf("callback url %q is not reachable by Swish in production", callbackURL)
+	return nil
+}
+
+// callbackIdentifierHeader is the header in which Swish sends the callback identifier of the request
+const callbackIdentifierHeader = "callbackIdentifier"
+
+// validateCallbackIdentifier checks that a callback identifier follows the Swish specification, an empty identifier
+// is valid since it is optional.
+func validateCallbackIdentifier(id string) error {
+	if id == "" {
+		return nil
+	}
+
+	if len(id) < 32 || len(id) > 36 {
+		return fmt.Errorf("callback identifier must be between 32 and 36 characters, got %d", len(id))
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("callback identifier contains invalid character %q", r)
+		}
+	}
+
+	return nil
+}
+
+// DecodeCallback reads the payment or refund that Swish posts to the callback URL. The callback identifier is read
+// from the callbackIdentifier header, so that it can be compared with the one used when the request was created.
+func DecodeCallback(r *http.Request) (result statusResponse, err error) {
+	defer r.Body.Close()
+
+	err = json.NewDecoder(r.Body).Decode(&result)
+	if err != nil {
+		return
+	}
+
+	if id := r.Header.Get(callbackIdentifierHeader); id != "" {
+		result.CallbackIdentifier = id
+	}
+
+	return
+}
+
+// CallbackPayload is the payment or refund that Swish posts to the callback URL, as returned by DecodeCallback
+type CallbackPayload = statusResponse
+
+// VerifyCallbackIdentifier reports whether the callback identifier of payload equals expected, the identifier that
+// was given when the request was created. The identifier is only known to the merchant and Swish, so if it is random
+// and kept secret, a matching identifier shows that the callback comes from Swish without asking Swish for the status.
+// It does not protect against replay of a callback, only the status from Swish is authoritative. The comparison takes
+// constant time, and an empty identifier never matches.
+func VerifyCallbackIdentifier(payload CallbackPayload, expected string) bool {
+	if payload.CallbackIdentifier == "" || expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(payload.CallbackIdentifier), []byte(expected)) == 1
+}
+
+// CallbackHandlerOptions configures CallbackHandler
+type CallbackHandlerOptions struct {
+	// Timeout is how long the handler waits for fn before it responds 200 to Swish anyway. Zero waits for fn to return
+	// however long it takes.
+	//
+	// Without a timeout the handler processes then acknowledges: Swish only gets 200 after fn succeeded, and a failing
+	// fn is answered with 500 so that Swish delivers the callback again. A slow fn then holds the connection, and if
+	// Swish gives up waiting it will retry a callback that may already be processed. With a timeout the handler
+	// acknowledges then processes asynchronously once fn is slow: Swish stops retrying, but an error from fn after the
+	// timeout is only logged, and the callback is not delivered again. Callers that use a timeout should be able to
+	// recover a lost callback by asking Swish for the status.
+	Timeout time.Duration
+
+	// CancelOnTimeout cancels the context passed to fn when Timeout is reached, otherwise fn keeps running in the
+	// background with a context that is cancelled once it returns.
+	CancelOnTimeout bool
+
+	// Logger receives errors from fn that happen after the handler has responded, nothing is logged if it is nil
+	Logger *log.Logger
+}
+
+// CallbackHandler returns an http.Handler for the callback URL that decodes the callback with DecodeCallback and
+// passes it to fn. A callback that can not be decoded is answered with 400, and an error from fn with 500 so that Swish
+// retries. See CallbackHandlerOptions for how a slow fn is handled.
+func CallbackHandler(fn func(ctx context.Context, payload CallbackPayload) error, opts CallbackHandlerOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := DecodeCallback(r)
+		if err != nil {
+			http.Error(w, "invalid callback", http.StatusBadRequest)
+			return
+		}
+
+		if opts.Timeout <= 0 {
+			if err := fn(r.Context(), payload); err != nil {
+				http.Error(w, "callback failed", http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// The request context ends when the handler returns, fn may outlive it
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			defer cancel()
+			done <- fn(ctx, payload)
+		}()
+
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				http.Error(w, "callback failed", http.StatusInternalServerError)
+				return
+			}
+		case <-timer.C:
+			if opts.CancelOnTimeout {
+				cancel()
+			}
+
+			go func() {
+				if err := <-done; err != nil {
+					logf(opts.Logger, "callback for %s failed after it was acknowledged: %s", payload.InstructionUUID, err)
+				}
+			}()
+		case <-r.Context().Done():
+			cancel()
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
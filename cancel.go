@@ -0,0 +1,74 @@
+package swish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrNotCancellable is returned when Swish refuses to cancel a payment request, e.g. since it has already been paid
+var ErrNotCancellable = errors.New("payment request can not be cancelled")
+
+// cancelPatch is the json patch that Swish expects to cancel a payment request
+var cancelPatch = []byte(`[{"op":"replace","path":"/status","value":"cancelled"}]`)
+
+// CancelPayment cancels a payment request that is still awaiting payment, using the location header from
+// CreatePaymentRequest. The returned status describes the payment request after it was cancelled.
+func (s *Swish) CancelPayment(ctx context.Context, location string) (result statusResponse, err error) {
+	err = s.checkLocation(location)
+	if err != nil {
+		return
+	}
+
+	req, err := s.newRequest(ctx, cancelPaymentRequest, location, cancelPatch)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	result.ResponseInfo = s.responseInfo(resp)
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+		var errCodes []errorResponse
+		errCodes, err = decodeErrors(resp.Body)
+		if err != nil {
+			return
+		}
+
+		for _, errCode := range errCodes {
+			result.ErrorCode = errCode.ErrorCode
+			result.ErrorMessage = errCode.ErrorMessage
+		}
+
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return result, s.codesError(resp.StatusCode, errCodes, ErrNotCancellable)
+		}
+
+		return result, s.codesError(resp.StatusCode, errCodes, nil)
+	}
+
+	if !cancelPaymentRequest.ok(resp.StatusCode) {
+		return result, httpError(resp)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return
+}
+
+// CancelPaymentByUUID cancels a payment request from the InstructionUUID it was created with, for when the location
+// header is not at hand.
+func (s *Swish) CancelPaymentByUUID(ctx context.Context, instructionUUID string) (statusResponse, error) {
+	id, err := formatInstructionUUID(instructionUUID)
+	if err != nil {
+		return statusResponse{}, err
+	}
+
+	return s.CancelPayment(ctx, getPaymentRequest.url(s.URL, id))
+}
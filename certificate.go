@@ -0,0 +1,137 @@
+package swish
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// testIssuers are the common names of the CAs that issue certificates for the Swish test environment
+var testIssuers = map[string]bool{
+	"Nordea Customer CA1 v2 for Swish": true,
+}
+
+// checkEnvironment verifies that cert is issued for the test environment if test is set, or otherwise for production
+func checkEnvironment(cert *x509.Certificate, test bool) error {
+	issuer := cert.Issuer.CommonName
+	if test && !testIssuers[issuer] {
+		return fmt.Errorf("certificate issued by %q is not a test certificate, but the test environment is selected", issuer)
+	}
+
+	if !test && testIssuers[issuer] {
+		return fmt.Errorf("certificate issued by %q is a test certificate, but the production environment is selected", issuer)
+	}
+
+	return nil
+}
+
+// LeafCertificate returns the client certificate that was loaded from the p12 certificate. It is parsed once when the
+// client is created.
+func (s *Swish) LeafCertificate() (*x509.Certificate, error) {
+	if s.snapshot.leaf == nil {
+		return nil, errors.New("no client certificate loaded")
+	}
+
+	return s.snapshot.leaf, nil
+}
+
+// CertificateMerchantNumber returns the Swish number of the merchant that the client certificate was issued to, which
+// Swish stores as the common name of the certificate subject.
+func (s *Swish) CertificateMerchantNumber() (string, error) {
+	number := s.snapshot.leaf.Subject.CommonName
+	if len(number) != 10 {
+		return "", fmt.Errorf("certificate subject %q is not a Swish number", number)
+	}
+
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("certificate subject %q is not a Swish number", number)
+		}
+	}
+
+	return number, nil
+}
+
+// RegisteredNumbers returns the Swish numbers that may be used as payee alias with the client certificate. Swish has
+// no endpoint listing the numbers registered to a certificate, so the single number is derived from the certificate
+// and nothing is fetched or cached.
+func (s *Swish) RegisteredNumbers(ctx context.Context) ([]string, error) {
+	number, err := s.CertificateMerchantNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{number}, nil
+}
+
+// merchantAlias returns alias, or the Swish number of the certificate if alias is empty and
+// Options.AliasFromCertificate is set. A warning is logged if alias is not one of the registered numbers, since Swish
+// then rejects the request with PA01.
+func (s *Swish) merchantAlias(ctx context.Context, alias string) string {
+	if alias == "" && !s.aliasFromCertificate {
+		return alias
+	}
+
+	numbers, err := s.RegisteredNumbers(ctx)
+	if err != nil {
+		return alias
+	}
+
+	if alias == "" {
+		return numbers[0]
+	}
+
+	for _, number := range numbers {
+		if alias == number {
+			return alias
+		}
+	}
+
+	s.logf("alias %s differs from the Swish number %s of the certificate", alias, strings.Join(numbers, ", "))
+	return alias
+}
+
+// verifyServerCertificate returns a tls.Config.VerifyConnection that verifies the chain presented by the server against
+// the CA as the handshake does, and logs the presented chain and the expected CA when it does not chain to it. It is
+// used in the test environment, where the server certificate sometimes is rotated before the bundled test CA. The
+// handshake is refused when the verification fails.
+func verifyServerCertificate(caPool *x509.CertPool, caCerts []*x509.Certificate, logger *log.Logger) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		chain := state.PeerCertificates
+		if len(chain) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := chain[0].Verify(x509.VerifyOptions{
+			DNSName:       state.ServerName,
+			Roots:         caPool,
+			Intermediates: intermediates,
+		})
+		if err == nil {
+			return nil
+		}
+
+		presented := make([]string, len(chain))
+		for i, cert := range chain {
+			presented[i] = fmt.Sprintf("%q issued by %q", cert.Subject, cert.Issuer)
+		}
+
+		expected := make([]string, len(caCerts))
+		for i, cert := range caCerts {
+			expected[i] = fmt.Sprintf("%q", cert.Subject)
+		}
+
+		logf(logger, "server certificate does not chain to the CA: %s; presented chain: %s; expected CA: %s",
+			err, strings.Join(presented, ", "), strings.Join(expected, ", "))
+		return err
+	}
+}
@@ -0,0 +1,110 @@
+package swish
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// curlRedactedHeaders are never included in a curl command
+var curlRedactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// curlRedactedFields are json fields of the body that are replaced in a curl command
+var curlRedactedFields = []string{"payerSSN", "callbackIdentifier"}
+
+// curlMaskedFields are json fields of the body that hold a phone number of a person, masked with MaskMSISDN
+var curlMaskedFields = []string{"payerAlias"}
+
+// curlTransport logs a curl command for requests that fail or get an error status
+type curlTransport struct {
+	next   http.RoundTripper
+	logger *log.Logger
+}
+
+func (t *curlTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		logf(t.logger, "request failed with %s, replay with: %s", err, curlCommand(req))
+	} else if resp.StatusCode >= 400 {
+		logf(t.logger, "request responded %s, replay with: %s", resp.Status, curlCommand(req))
+	}
+
+	return resp, err
+}
+
+// curlCommand returns a curl command that makes the same request as req, with secrets left out. The body is read
+// through req.GetBody, so req itself is not consumed.
+func curlCommand(req *http.Request) string {
+	parts := []string{"curl", "-X", req.Method, "--cert", "client.pem", "--key", "client.key"}
+
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		if !curlRedactedHeaders[http.CanonicalHeaderKey(key)] {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range req.Header[key] {
+			parts = append(parts, "-H", shellQuote(key+": "+value))
+		}
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := ioutil.ReadAll(body)
+			body.Close()
+			if len(b) > 0 {
+				parts = append(parts, "--data", shellQuote(redactBody(b)))
+			}
+		}
+	}
+
+	return strings.Join(append(parts, shellQuote(req.URL.String())), " ")
+}
+
+// redactBody replaces the values of curlRedactedFields and masks the values of curlMaskedFields in a json object
+func redactBody(b []byte) string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) != nil {
+		return string(b)
+	}
+
+	redacted := false
+	for _, field := range curlRedactedFields {
+		if _, ok := fields[field]; ok {
+			fields[field] = json.RawMessage(`"REDACTED"`)
+			redacted = true
+		}
+	}
+
+	for _, field := range curlMaskedFields {
+		var number string
+		if json.Unmarshal(fields[field], &number) != nil {
+			continue
+		}
+
+		fields[field], _ = json.Marshal(MaskMSISDN(number))
+		redacted = true
+	}
+
+	if !redacted {
+		return string(b)
+	}
+
+	out, _ := json.Marshal(fields)
+	return string(out)
+}
+
+// shellQuote quotes s as a single argument for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
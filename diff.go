@@ -0,0 +1,54 @@
+package swish
+
+// FieldDiff is a field that differs between a payment request and the payment that Swish reports
+type FieldDiff struct {
+	// Field is the json name of the field
+	Field string
+	// Sent is the value in the payment request
+	Sent string
+	// Got is the value that Swish reports
+	Got string
+}
+
+// DiffPayment compares a payment request with the status of the payment and returns the fields that differ. Only
+// the amount, currency, payeeAlias, message and payeePaymentReference are compared, since those are echoed by Swish.
+// Amounts are compared in minor units, so "100.1" and 100.10 are equal.
+func DiffPayment(sent CreatePaymentRequestOptions, got statusResponse) []FieldDiff {
+	var diffs []FieldDiff
+	compare := func(field, sent, got string) {
+		if sent != got {
+			diffs = append(diffs, FieldDiff{Field: field, Sent: sent, Got: got})
+		}
+	}
+
+	gotAmount := formatMinorUnits(floatMinorUnits(got.Amount))
+	if units, err := parseMinorUnits(sent.Amount); err != nil || units != floatMinorUnits(got.Amount) {
+		diffs = append(diffs, FieldDiff{Field: "amount", Sent: sent.Amount, Got: gotAmount})
+	}
+
+	compare("currency", sent.Currency, got.Currency)
+	compare("payeeAlias", sent.PayeeAlias, got.PayeeAlias)
+	compare("message", sent.Message, got.Message)
+	compare("payeePaymentReference", sent.PayeePaymentReference, got.PayeePaymentReference)
+
+	return diffs
+}
+
+// CompareToRequest reports for every field that Swish echoes whether it matches the payment request, keyed by the json
+// name of the field. A false value means that Swish altered or dropped the field, e.g. truncated the message. The
+// fields and comparison are the same as in DiffPayment.
+func (r statusResponse) CompareToRequest(opts CreatePaymentRequestOptions) map[string]bool {
+	fields := map[string]bool{
+		"amount":                true,
+		"currency":              true,
+		"payeeAlias":            true,
+		"message":               true,
+		"payeePaymentReference": true,
+	}
+
+	for _, diff := range DiffPayment(opts, r) {
+		fields[diff.Field] = false
+	}
+
+	return fields
+}
@@ -0,0 +1,84 @@
+package swish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	jsonContentType      = "application/json"
+	jsonPatchContentType = "application/json-patch+json"
+)
+
+// endpointSpec declares how an operation talks with Swish
+type endpointSpec struct {
+	// method is the http method of the request
+	method string
+	// path is a fmt template of the path relative to the base URL, it is empty for operations that follow a location
+	path string
+	// contentType is sent with requests that have a body
+	contentType string
+	// accept is the Accept header of the request, no header is sent if it is empty
+	accept string
+	// successCodes are the status codes of a successful response, any status code is accepted if it is empty
+	successCodes []int
+}
+
+var (
+	createPaymentRequestV1 = endpointSpec{method: http.MethodPost, path: "/swish-cpcapi/api/v1/paymentrequests", contentType: jsonContentType, accept: jsonContentType, successCodes: []int{http.StatusCreated}}
+	createPaymentRequestV2 = endpointSpec{method: http.MethodPut, path: "/swish-cpcapi/api/v2/paymentrequests/%s", contentType: jsonContentType, accept: jsonContentType, successCodes: []int{http.StatusCreated}}
+	createRefundV1         = endpointSpec{method: http.MethodPost, path: "/swish-cpcapi/api/v1/refunds", contentType: jsonContentType, accept: jsonContentType, successCodes: []int{http.StatusCreated}}
+	createRefundV2         = endpointSpec{method: http.MethodPut, path: "/swish-cpcapi/api/v2/refunds/%s", contentType: jsonContentType, accept: jsonContentType, successCodes: []int{http.StatusCreated}}
+	getPaymentRequest      = endpointSpec{method: http.MethodGet, path: "/swish-cpcapi/api/v1/paymentrequests/%s", accept: jsonContentType}
+	getStatus              = endpointSpec{method: http.MethodGet, accept: jsonContentType, successCodes: []int{http.StatusOK}}
+	cancelPaymentRequest   = endpointSpec{method: http.MethodPatch, contentType: jsonPatchContentType, accept: jsonContentType, successCodes: []int{http.StatusOK}}
+	getPayoutStatus        = endpointSpec{method: http.MethodGet, accept: jsonContentType, successCodes: []int{http.StatusOK}}
+	generatePrefilledQR    = endpointSpec{method: http.MethodPost, path: "/api/v1/prefilled", contentType: jsonContentType, successCodes: []int{http.StatusOK}}
+)
+
+// url returns the URL of the endpoint at base, with args filled into the path
+func (e endpointSpec) url(base string, args ...interface{}) string {
+	return base + fmt.Sprintf(e.path, args...)
+}
+
+// ok reports whether code is a successful status code of the endpoint
+func (e endpointSpec) ok(code int) bool {
+	if len(e.successCodes) == 0 {
+		return true
+	}
+
+	for _, c := range e.successCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRequest builds a request to the endpoint at target, with the headers the endpoint declares
+func (s *Swish) newRequest(ctx context.Context, e endpointSpec, target string, body []byte) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequestWithContext(ctx, e.method, target, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, e.method, target, bytes.NewReader(body))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil && e.contentType != "" {
+		req.Header.Set("Content-Type", e.contentType)
+	}
+
+	if e.accept != "" {
+		req.Header.Set("Accept", e.accept)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,65 @@
+package swish
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// NewFromEnv creates a new client configured from environment variables:
+//
+//	SWISH_CERT_PATH   required, path to the p12 encoded SSL certificate
+//	SWISH_PASSPHRASE  required, passphrase of the SSL certificate, it may be set to an empty value
+//	SWISH_CA_PATH     optional, path to a PEM encoded CA, defaults to Certificate
+//	SWISH_TEST        optional, "true" selects the test environment
+//	SWISH_TIMEOUT     optional, client timeout in seconds
+func NewFromEnv() (*Swish, error) {
+	certPath, ok := os.LookupEnv("SWISH_CERT_PATH")
+	if !ok || certPath == "" {
+		return nil, errors.New("missing environment variable SWISH_CERT_PATH")
+	}
+
+	passphrase, ok := os.LookupEnv("SWISH_PASSPHRASE")
+	if !ok {
+		return nil, errors.New("missing environment variable SWISH_PASSPHRASE")
+	}
+
+	cert, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read SWISH_CERT_PATH: %w", err)
+	}
+
+	opts := Options{
+		Passphrase:     passphrase,
+		SSLCertificate: cert,
+		CA:             Certificate,
+	}
+
+	if caPath := os.Getenv("SWISH_CA_PATH"); caPath != "" {
+		ca, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read SWISH_CA_PATH: %w", err)
+		}
+
+		opts.CA = base64.StdEncoding.EncodeToString(ca)
+	}
+
+	if test := os.Getenv("SWISH_TEST"); test != "" {
+		opts.Test, err = strconv.ParseBool(test)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SWISH_TEST %q: %w", test, err)
+		}
+	}
+
+	if timeout := os.Getenv("SWISH_TIMEOUT"); timeout != "" {
+		opts.Timeout, err = strconv.Atoi(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SWISH_TIMEOUT %q: %w", timeout, err)
+		}
+	}
+
+	return New(opts)
+}
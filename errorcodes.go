@@ -0,0 +1,79 @@
+package swish
+
+// ErrorCode is an error code documented by Swish
+type ErrorCode string
+
+const (
+	ErrorCodeFF08     ErrorCode = "FF08"
+	ErrorCodeRP03     ErrorCode = "RP03"
+	ErrorCodeBE18     ErrorCode = "BE18"
+	ErrorCodeRP01     ErrorCode = "RP01"
+	ErrorCodePA01     ErrorCode = "PA01"
+	ErrorCodePA02     ErrorCode = "PA02"
+	ErrorCodeAM02     ErrorCode = "AM02"
+	ErrorCodeAM03     ErrorCode = "AM03"
+	ErrorCodeAM06     ErrorCode = "AM06"
+	ErrorCodeRP02     ErrorCode = "RP02"
+	ErrorCodeRP04     ErrorCode = "RP04"
+	ErrorCodeRP06     ErrorCode = "RP06"
+	ErrorCodeRP09     ErrorCode = "RP09"
+	ErrorCodeACMT01   ErrorCode = "ACMT01"
+	ErrorCodeACMT03   ErrorCode = "ACMT03"
+	ErrorCodeACMT07   ErrorCode = "ACMT07"
+	ErrorCodeRF02     ErrorCode = "RF02"
+	ErrorCodeRF03     ErrorCode = "RF03"
+	ErrorCodeRF04     ErrorCode = "RF04"
+	ErrorCodeRF06     ErrorCode = "RF06"
+	ErrorCodeRF07     ErrorCode = "RF07"
+	ErrorCodeRF08     ErrorCode = "RF08"
+	ErrorCodeRF09     ErrorCode = "RF09"
+	ErrorCodeVR01     ErrorCode = "VR01"
+	ErrorCodeVR02     ErrorCode = "VR02"
+	ErrorCodeBANKIDCL ErrorCode = "BANKIDCL"
+	ErrorCodeFF10     ErrorCode = "FF10"
+	ErrorCodeTM01     ErrorCode = "TM01"
+	ErrorCodeDS24     ErrorCode = "DS24"
+)
+
+// errorCodeCatalog maps every ErrorCode to the description given by Swish
+var errorCodeCatalog = map[ErrorCode]string{
+	ErrorCodeFF08:     "PaymentReference is invalid",
+	ErrorCodeRP03:     "Callback URL is missing or does not use HTTPS",
+	ErrorCodeBE18:     "Payer alias is invalid",
+	ErrorCodeRP01:     "Missing Merchant Swish Number",
+	ErrorCodePA01:     "The payeeAlias in the payment request object is not the same as merchant’s Swish number",
+	ErrorCodePA02:     "Amount value is missing or not a valid number",
+	ErrorCodeAM02:     "Amount value is too large",
+	ErrorCodeAM03:     "Invalid or missing Currency",
+	ErrorCodeAM06:     "Specified transaction amount is less than agreed minimum",
+	ErrorCodeRP02:     "Wrong formatted message",
+	ErrorCodeRP04:     "No payment request found related to a token",
+	ErrorCodeRP06:     "A payment request already exists for that payer",
+	ErrorCodeRP09:     "The given instructionUUID is not available",
+	ErrorCodeACMT01:   "Counterpart is not activated",
+	ErrorCodeACMT03:   "Payer not Enrolled",
+	ErrorCodeACMT07:   "Payee not Enrolled",
+	ErrorCodeRF02:     "Original Payment not found or original payment is more than 13 months old",
+	ErrorCodeRF03:     "Payer alias in the refund does not match the payee alias in the original payment",
+	ErrorCodeRF04:     "Payer organization number do not match original payment payee organization number",
+	ErrorCodeRF06:     "The Payer SSN in the original payment is not the same as the SSN for the current Payee",
+	ErrorCodeRF07:     "Transaction declined",
+	ErrorCodeRF08:     "Amount value is too large, or amount exceeds the amount of the original payment minus any previous refunds",
+	ErrorCodeRF09:     "Refund already in progress",
+	ErrorCodeVR01:     "Payer does not meet age limit",
+	ErrorCodeVR02:     "The payer alias in the request is not enrolled in Swish with the supplied SSN",
+	ErrorCodeBANKIDCL: "Payer cancelled BankID signing",
+	ErrorCodeFF10:     "Bank system processing error",
+	ErrorCodeTM01:     "Swish timed out before the payment was started",
+	ErrorCodeDS24:     "Swish timed out waiting for an answer from the banks after payment was started",
+}
+
+// ErrorCodeCatalog returns every documented error code mapped to its description
+func ErrorCodeCatalog() map[ErrorCode]string {
+	catalog := make(map[ErrorCode]string, len(errorCodeCatalog))
+	for code, description := range errorCodeCatalog {
+		catalog[code] = description
+	}
+
+	return catalog
+}
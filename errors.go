@@ -0,0 +1,168 @@
+package swish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrMaintenance is matched by a MaintenanceError
+var ErrMaintenance = errors.New("swish is under maintenance")
+
+// maxMaintenanceBody is how much of the body of a 503 is read to recognize a maintenance window
+const maxMaintenanceBody = 4 << 10
+
+// Error is returned when Swish rejects a request with error codes. It may wrap a sentinel error such as
+// ErrDuplicateInstruction or ErrNotCancellable. Retrying the same request gives the same error.
+type Error struct {
+	// StatusCode is the http status code of the response
+	StatusCode int
+	// Codes are the error codes that Swish responded with
+	Codes []ErrorResponse
+
+	message string
+	err     error
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// HTTPError is returned when Swish responds with a status code that the request does not expect, such as a 5xx or a
+// redirect.
+type HTTPError struct {
+	// StatusCode is the http status code of the response
+	StatusCode int
+	// Status is the status line of the response, e.g. "503 Service Unavailable"
+	Status string
+	// Method is the method of the request
+	Method string
+	// URL is the URL of the request
+	URL string
+	// Location is the target of a redirect
+	Location string
+}
+
+func (e *HTTPError) Error() string {
+	if e.StatusCode >= 300 && e.StatusCode < 400 {
+		return fmt.Sprintf("unexpected redirect %s to %q", e.Status, e.Location)
+	}
+
+	return fmt.Sprintf("unexpected response from %s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// NetworkError is returned when a request could not be sent or no response was received, e.g. on a failed dial, a
+// timeout or a reset connection. Whether Swish received the request is unknown, so only retry requests that are
+// idempotent.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("network error: %s", e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// MaintenanceError is returned when Swish responds with 503 during a maintenance window. It matches ErrMaintenance
+// with errors.Is, and unwraps to the HTTPError of the response. It is never retried, wait for RetryAfter instead.
+type MaintenanceError struct {
+	HTTPError
+	// RetryAfter is how long Swish asks to wait before the next request, it is zero if no Retry-After was sent
+	RetryAfter time.Duration
+}
+
+func (e *MaintenanceError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry after %s", ErrMaintenance, e.RetryAfter)
+	}
+
+	return ErrMaintenance.Error()
+}
+
+func (e *MaintenanceError) Is(target error) bool {
+	return target == ErrMaintenance
+}
+
+func (e *MaintenanceError) Unwrap() error {
+	return &e.HTTPError
+}
+
+// do sends req and wraps transport errors in NetworkError. Errors caused by the context of req being done are
+// returned as is, since they are not failures of the network.
+func (s *Swish) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err == nil {
+		return resp, nil
+	}
+
+	if ctxErr := req.Context().Err(); ctxErr == context.Canceled || ctxErr == context.DeadlineExceeded {
+		return nil, err
+	}
+
+	return nil, &NetworkError{Err: err}
+}
+
+// codesError builds the Error of a response with error codes, its message is prefixed with sentinel if it is set
+func (s *Swish) codesError(statusCode int, codes []errorResponse, sentinel error) error {
+	message := s.formatErrors(codes)
+	if sentinel != nil {
+		message = fmt.Sprintf("%s: %s", sentinel, message)
+	}
+
+	return &Error{StatusCode: statusCode, Codes: codes, message: message, err: sentinel}
+}
+
+// httpError builds the HTTPError of an unexpected response, or a MaintenanceError if it is a 503 whose body mentions
+// maintenance
+func httpError(resp *http.Response) error {
+	httpErr := HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		Location:   resp.Header.Get("Location"),
+	}
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return &httpErr
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMaintenanceBody))
+	if err != nil || !bytes.Contains(bytes.ToLower(body), []byte("maintenance")) {
+		return &httpErr
+	}
+
+	return &MaintenanceError{HTTPError: httpErr, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as a date. It returns zero if the header is
+// missing, malformed or in the past.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0
+	}
+
+	if wait := time.Until(date); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
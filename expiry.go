@@ -0,0 +1,54 @@
+package swish
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CreatePaymentRequestWithExpiry creates a payment request and cancels it if it is still CREATED after expiry, e.g. to
+// release the reservation of an order. The returned stop func stops the auto-cancel, call it when the payment is done
+// or no longer needs to be cancelled. The auto-cancel also stops when ctx is done, so ctx must live longer than
+// expiry, a context of an incoming http request usually does not. Failures of the auto-cancel are logged.
+func (s *Swish) CreatePaymentRequestWithExpiry(ctx context.Context, opts CreatePaymentRequestOptions, expiry time.Duration) (createPaymentRequestResponse, func(), error) {
+	result, err := s.CreatePaymentRequest(ctx, opts)
+	if err != nil {
+		return result, func() {}, err
+	}
+
+	stopped := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(stopped) })
+	}
+
+	go func() {
+		timer := time.NewTimer(expiry)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopped:
+			return
+		case <-timer.C:
+		}
+
+		status, err := s.Status(ctx, result.Location)
+		if err != nil {
+			s.logf("could not get status of expired payment request %s: %s", result.InstructionUUID, err)
+			return
+		}
+
+		if status.Status != "CREATED" {
+			return
+		}
+
+		_, err = s.CancelPayment(ctx, result.Location)
+		if err != nil {
+			s.logf("could not cancel expired payment request %s: %s", result.InstructionUUID, err)
+		}
+	}()
+
+	return result, stop, nil
+}
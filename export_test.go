@@ -0,0 +1,94 @@
+package swish
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// SetNow replaces the clock of the client
+func SetNow(s *Swish, now func() time.Time) {
+	s.now = now
+}
+
+// SetTest selects the test or production environment of the client, without changing the endpoint
+func SetTest(s *Swish, test bool) {
+	s.test = test
+}
+
+// SetJitterRand replaces the random source of the retry jitter
+func SetJitterRand(s *Swish, r *rand.Rand) {
+	s.jitterRand = r
+}
+
+// RetryDelay is the wait before a retry with the given backoff
+func RetryDelay(s *Swish, backoff time.Duration) time.Duration {
+	return s.retryDelay(backoff)
+}
+
+// SetLatency delays every request of the client, for testing timeout and retry handling
+func SetLatency(s *Swish, latency time.Duration) {
+	s.client.Transport = &latencyTransport{next: s.client.Transport, latency: latency}
+}
+
+// latencyTransport delays every request before passing it on to the next transport
+type latencyTransport struct {
+	next    http.RoundTripper
+	latency time.Duration
+}
+
+func (t *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.NewTimer(t.latency)
+	defer timer.Stop()
+
+	select {
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-timer.C:
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// Transport returns the http transport of the client
+func Transport(s *Swish) *http.Transport {
+	roundTripper := s.client.Transport
+	for {
+		switch t := roundTripper.(type) {
+		case *http.Transport:
+			return t
+		case *latencyTransport:
+			roundTripper = t.next
+		case *gzipTransport:
+			roundTripper = t.next
+		case *headerTransport:
+			roundTripper = t.next
+		case *limitTransport:
+			roundTripper = t.next
+		case *curlTransport:
+			roundTripper = t.next
+		default:
+			return nil
+		}
+	}
+}
+
+// StatusResponse is the status of a payment request or refund
+type StatusResponse = statusResponse
+
+// RefundStatusResponse is the status of a refund
+type RefundStatusResponse = refundStatusResponse
+
+// ValidatePEMBlocks checks the decoded blocks of a p12 certificate
+var ValidatePEMBlocks = validatePEMBlocks
+
+// InFlight returns the number of tracked requests that Abort can cancel
+func InFlight(s *Swish) (n int) {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	for _, cancels := range s.inflight {
+		n += len(cancels)
+	}
+
+	return
+}
@@ -0,0 +1,31 @@
+package swish
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// Fingerprint returns a SHA-256 hex hash of the business fields of the payment request: PayeeAlias, Amount, Currency,
+// PayeePaymentReference and Message. The InstructionUUID, CallbackURL and payer fields are not included, so two
+// requests for the same payment yield the same fingerprint even though they have different identifiers. Amounts are
+// compared in minor units, so "100.1" and "100.10" yield the same fingerprint.
+func (opts CreatePaymentRequestOptions) Fingerprint() string {
+	amount := strings.TrimSpace(opts.Amount)
+	if units, err := parseMinorUnits(amount); err == nil {
+		amount = formatMinorUnits(units)
+	}
+
+	// Encoding the fields as a json array keeps the boundaries between them unambiguous
+	fields, _ := json.Marshal([]string{
+		strings.TrimSpace(opts.PayeeAlias),
+		amount,
+		strings.ToUpper(strings.TrimSpace(opts.Currency)),
+		strings.TrimSpace(opts.PayeePaymentReference),
+		strings.TrimSpace(opts.Message),
+	})
+
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
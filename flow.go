@@ -0,0 +1,27 @@
+package swish
+
+// Flow tells how the payer approves a payment request
+type Flow int
+
+const (
+	// FlowUnknown is a payment request that was created without the options at hand, e.g. with
+	// CreatePaymentRequestRaw
+	FlowUnknown Flow = iota
+
+	// ECommerce is a payment request with a PayerAlias. Swish sends it to the app of the payer, who approves it there.
+	ECommerce
+
+	// MCommerce is a payment request without a PayerAlias. The merchant opens the Swish app with the
+	// PaymentRequestToken, or shows it as a QR code.
+	MCommerce
+)
+
+// paymentFlow returns the flow of a payment request created with payerAlias, and whether it is waiting for the payer
+// to approve it in the app, which is the case for an e-commerce payment request that got no token
+func paymentFlow(payerAlias, token string) (Flow, bool) {
+	if payerAlias == "" {
+		return MCommerce, false
+	}
+
+	return ECommerce, token == ""
+}
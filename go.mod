@@ -5,5 +5,6 @@ go 1.15
 require (
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.6.1
-	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29
+	software.sslmate.com/src/go-pkcs12 v0.2.0
 )
@@ -0,0 +1,36 @@
+package swish
+
+import (
+	"math"
+	"time"
+)
+
+// HealthReport describes the client certificate and the CA, e.g. to emit as metrics and alert on before the
+// certificate expires
+type HealthReport struct {
+	// CertificateNotAfter is when the client certificate expires
+	CertificateNotAfter time.Time
+	// DaysRemaining is the number of whole days until the client certificate expires, it is negative once it has
+	// expired
+	DaysRemaining int
+	// CACertificates is the number of certificates in the CA pool
+	CACertificates int
+	// Environment is "test" or "production"
+	Environment string
+}
+
+// Health returns a report on the client certificate and the CA of the client
+func (s *Swish) Health() HealthReport {
+	notAfter := s.snapshot.leaf.NotAfter
+	environment := "production"
+	if s.test {
+		environment = "test"
+	}
+
+	return HealthReport{
+		CertificateNotAfter: notAfter,
+		DaysRemaining:       int(math.Floor(notAfter.Sub(s.now()).Hours() / 24)),
+		CACertificates:      len(s.snapshot.caCerts),
+		Environment:         environment,
+	}
+}
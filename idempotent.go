@@ -0,0 +1,66 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CreatePaymentRequestIdempotent creates a payment request like CreatePaymentRequest, but if Swish reports that the
+// InstructionUUID is already used, the status of the existing payment request is fetched and returned instead. This
+// makes it safe to retry a create, also after a restart, as long as the same InstructionUUID is used. The
+// InstructionUUID is therefore required, and so is V2 since V1 can not fetch a payment request by InstructionUUID. If
+// the amount, payee or currency of the existing payment request differ from opts, the InstructionUUID is used by
+// another request and the error wrapping ErrDuplicateInstruction is returned.
+func (s *Swish) CreatePaymentRequestIdempotent(ctx context.Context, opts CreatePaymentRequestOptions) (createPaymentRequestResponse, error) {
+	if s.apiVersion != V2 {
+		return createPaymentRequestResponse{}, errors.New("idempotent payment requests require V2")
+	}
+
+	if opts.InstructionUUID == "" {
+		return createPaymentRequestResponse{}, errors.New("instruction uuid is required")
+	}
+
+	id, err := formatInstructionUUID(opts.InstructionUUID)
+	if err != nil {
+		return createPaymentRequestResponse{}, err
+	}
+
+	opts.InstructionUUID = id
+	result, createErr := s.CreatePaymentRequest(ctx, opts)
+	if !errors.Is(createErr, ErrDuplicateInstruction) {
+		return result, createErr
+	}
+
+	location := getPaymentRequest.url(s.URL, id)
+	status, err := s.Status(ctx, location)
+	if err != nil {
+		return result, fmt.Errorf("could not fetch existing payment request: %w", err)
+	}
+
+	sent, err := s.preparePaymentRequest(ctx, opts)
+	if err != nil {
+		return result, err
+	}
+
+	var differs []string
+	for _, diff := range DiffPayment(sent, status) {
+		switch {
+		case diff.Field == "payeeAlias" && diff.Sent == "":
+			// Swish fills in the payee of the certificate
+		case diff.Field == "amount", diff.Field == "currency", diff.Field == "payeeAlias":
+			differs = append(differs, fmt.Sprintf("%s %q, existing %q", diff.Field, diff.Sent, diff.Got))
+		}
+	}
+
+	if len(differs) > 0 {
+		return result, fmt.Errorf("existing payment request differs in %s: %w", strings.Join(differs, ", "), createErr)
+	}
+
+	return createPaymentRequestResponse{
+		InstructionUUID: id,
+		Location:        location,
+		ResponseInfo:    status.ResponseInfo,
+	}, nil
+}
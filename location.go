@@ -0,0 +1,76 @@
+package swish
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// locationPattern matches the path of a payment request, refund or payout location in any API version
+var locationPattern = regexp.MustCompile("^/swish-cpcapi/api/v[0-9]+/(paymentrequests|refunds|payouts)/([^/]+)$")
+
+// ValidateLocation checks that location points to a payment request, refund or payout at the endpoint of the client or
+// one of Options.LocationHosts, and that its identifier is well formed. Following a location from untrusted input
+// without this check would let anyone make the client send requests to any host.
+func (s *Swish) ValidateLocation(location string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid location: %w", err)
+	}
+
+	ok, err := s.atEndpoint(u)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("location %q is not at the endpoint %s", location, s.URL)
+	}
+
+	match := locationPattern.FindStringSubmatch(u.Path)
+	if match == nil {
+		return fmt.Errorf("location %q is not a payment request, refund or payout", location)
+	}
+
+	_, err = formatInstructionUUID(match[2])
+	if err != nil {
+		return fmt.Errorf("location %q has an invalid id: %w", location, err)
+	}
+
+	return nil
+}
+
+// atEndpoint reports whether u has the scheme of the endpoint and its host or one of Options.LocationHosts
+func (s *Swish) atEndpoint(u *url.URL) (bool, error) {
+	endpoint, err := url.Parse(s.URL)
+	if err != nil {
+		return false, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	return strings.EqualFold(u.Scheme, endpoint.Scheme) && s.locationHostAllowed(u.Host, endpoint.Host), nil
+}
+
+// locationHostAllowed reports whether host is the host of the endpoint or one of Options.LocationHosts
+func (s *Swish) locationHostAllowed(host, endpointHost string) bool {
+	if strings.EqualFold(host, endpointHost) {
+		return true
+	}
+
+	for _, allowed := range s.locationHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkLocation validates a location before it is followed, unless Options.DisableLocationCheck is set
+func (s *Swish) checkLocation(location string) error {
+	if s.disableLocationCheck {
+		return nil
+	}
+
+	return s.ValidateLocation(location)
+}
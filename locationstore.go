@@ -0,0 +1,97 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrLocationNotFound is returned by StatusByStoredUUID when no location is stored for the InstructionUUID
+var ErrLocationNotFound = errors.New("no location stored")
+
+// LocationStore persists the location of every payment request and refund that is created, so that its status can be
+// fetched by InstructionUUID with StatusByStoredUUID. The InstructionUUID is given as 32 upper case hexadecimal
+// characters. The location of a paid payment request is also saved under "paymentReference:" and the upper case
+// payment reference from the bank, for PaymentForRefund. Implementations must be safe for concurrent use.
+type LocationStore interface {
+	Save(uuid, location string)
+	Load(uuid string) (string, bool)
+}
+
+// MemoryLocationStore is a LocationStore that keeps the locations in memory, for the lifetime of the process. Nothing is
+// ever removed, so it suits tests and short-lived processes, a long-running server should use a store with expiry.
+type MemoryLocationStore struct {
+	mu        sync.RWMutex
+	locations map[string]string
+}
+
+// NewMemoryLocationStore returns an empty MemoryLocationStore
+func NewMemoryLocationStore() *MemoryLocationStore {
+	return &MemoryLocationStore{locations: make(map[string]string)}
+}
+
+// Save stores the location of uuid
+func (m *MemoryLocationStore) Save(uuid, location string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locations[uuid] = location
+}
+
+// Load returns the location of uuid, and whether there is one
+func (m *MemoryLocationStore) Load(uuid string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	location, ok := m.locations[uuid]
+	return location, ok
+}
+
+// saveLocation stores location under the InstructionUUID that the request was created with, if a LocationStore is set.
+// With V1 the location ends with an identifier from Swish, so it can not be used as key.
+func (s *Swish) saveLocation(instructionUUID, location string) {
+	if s.locations == nil || location == "" {
+		return
+	}
+
+	if key := instructionKey(instructionUUID); key != "" {
+		s.locations.Save(key, location)
+	}
+}
+
+// paymentReferenceKey is the key of the location of the payment with the given payment reference from the bank
+func paymentReferenceKey(paymentReference string) string {
+	return "paymentReference:" + strings.ToUpper(paymentReference)
+}
+
+// savePaymentReference stores the location of a payment request under its payment reference, if a LocationStore is
+// set. A refund refers to the payment by this reference, which Swish can not look up.
+func (s *Swish) savePaymentReference(location, paymentReference string) {
+	if s.locations == nil || paymentReference == "" {
+		return
+	}
+
+	u, err := url.Parse(location)
+	if err != nil || !strings.Contains(u.Path, "/paymentrequests/") {
+		return
+	}
+
+	s.locations.Save(paymentReferenceKey(paymentReference), location)
+}
+
+// StatusByStoredUUID gets the status of the payment request or refund that was created with instructionUUID, from the
+// location in Options.LocationStore. An error wrapping ErrLocationNotFound is returned if none is stored, or if no
+// LocationStore is set.
+func (s *Swish) StatusByStoredUUID(ctx context.Context, instructionUUID string) (statusResponse, error) {
+	if s.locations == nil {
+		return statusResponse{}, fmt.Errorf("%w: Options.LocationStore is not set", ErrLocationNotFound)
+	}
+
+	location, ok := s.locations.Load(instructionKey(instructionUUID))
+	if !ok {
+		return statusResponse{}, fmt.Errorf("%w: %s", ErrLocationNotFound, instructionUUID)
+	}
+
+	return s.Status(ctx, location)
+}
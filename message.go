@@ -0,0 +1,53 @@
+package swish
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxMessageLength is the maximum number of characters of the message of a payment request
+const MaxMessageLength = 50
+
+// messageChars are the characters besides a-z, A-Z and 0-9 that Swish allows in a message. The - is last, so that it
+// is not a range in messagePattern.
+const messageChars = "åäöÅÄÖ:;.,?!()”\" -"
+
+// messagePattern matches a message that only holds characters that Swish allows
+const messagePattern = "^[a-zA-Z0-9" + messageChars + "]*$"
+
+// applyMessageTemplate fills in the message template of the client when the message of opts is empty. The
+// {payeePaymentReference} placeholder is replaced by the reference. The result is checked against the characters that
+// Swish allows, and is truncated to MaxMessageLength or rejected depending on Options.TruncateMessage.
+func (s *Swish) applyMessageTemplate(opts *CreatePaymentRequestOptions) error {
+	if s.messageTemplate == "" || opts.Message != "" {
+		return nil
+	}
+
+	message := strings.Replace(s.messageTemplate, "{payeePaymentReference}", opts.PayeePaymentReference, -1)
+	for _, r := range message {
+		if !messageRune(r) {
+			return fmt.Errorf("message %q from template contains invalid character %q", message, r)
+		}
+	}
+
+	if utf8.RuneCountInString(message) > MaxMessageLength {
+		if !s.truncateMessage {
+			return fmt.Errorf("message %q from template is longer than %d characters", message, MaxMessageLength)
+		}
+
+		message = string([]rune(message)[:MaxMessageLength])
+	}
+
+	opts.Message = message
+	return nil
+}
+
+// messageRune reports whether Swish allows r in a message, as matched by messagePattern
+func messageRune(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+
+	return strings.ContainsRune(messageChars, r)
+}
@@ -0,0 +1,63 @@
+package swish
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maskPrefix = "•••• "
+
+// MaskMSISDN masks a Swish number for display, keeping only the last 4 digits, e.g. "•••• 9304". Characters that are
+// not digits are ignored. A number with 4 digits or less is masked completely, and an empty number stays empty.
+func MaskMSISDN(number string) string {
+	var digits strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+
+	if digits.Len() == 0 {
+		return ""
+	}
+
+	if digits.Len() <= 4 {
+		return strings.TrimSpace(maskPrefix)
+	}
+
+	return maskPrefix + digits.String()[digits.Len()-4:]
+}
+
+// NormalizeMSISDN turns a Swedish phone number as entered by a person into the format Swish expects, e.g.
+// "070-123 45 67", "+46 70 123 45 67" and "0046701234567" all become "46701234567". Spaces, dashes and parentheses are
+// removed, a leading + or 00 is dropped, a leading 0 is replaced by the country code 46, and a 0 after the country code
+// is dropped. It is an error if the result is not 8 to 15 digits.
+func NormalizeMSISDN(input string) (string, error) {
+	number := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')':
+			return -1
+		}
+
+		return r
+	}, input)
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case strings.HasPrefix(number, "0"):
+		number = "46" + number[1:]
+	}
+
+	if strings.HasPrefix(number, "460") {
+		number = "46" + number[3:]
+	}
+
+	if !msisdnPattern.MatchString(number) {
+		return "", fmt.Errorf("invalid phone number %q", input)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,36 @@
+package swish
+
+// RequestOption changes how a single request is made
+type RequestOption func(*requestOptions)
+
+// requestOptions holds the settings of a single request
+type requestOptions struct {
+	instructionUUID string
+	appSwitch       bool
+}
+
+// newRequestOptions applies opts to the default request settings
+func newRequestOptions(opts []RequestOption) requestOptions {
+	var o requestOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithInstructionUUID sets the identifier of the request, it takes precedence over the InstructionUUID of the options
+// struct. The identifier is formatted to the 32 upper case hexadecimal characters that Swish expects.
+func WithInstructionUUID(id string) RequestOption {
+	return func(o *requestOptions) {
+		o.instructionUUID = id
+	}
+}
+
+// WithAppSwitch tells CreateAndAwait that the caller opens the Swish app with the PaymentRequestToken itself, so an
+// m-commerce payment request returns right after it is created instead of being polled
+func WithAppSwitch() RequestOption {
+	return func(o *requestOptions) {
+		o.appSwitch = true
+	}
+}
@@ -0,0 +1,29 @@
+package swish
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// payerKey is the context key of the payer set with WithPayer
+type payerKey struct{}
+
+var msisdnPattern = regexp.MustCompile("^[0-9]{8,15}$")
+
+// WithPayer returns a context that carries the Swish number of the payer, e.g. of the logged-in customer. It is used
+// as PayerAlias by CreatePaymentRequest when the options leave it empty, an explicit PayerAlias always wins. Note that
+// a PayerAlias makes the payment request an e-commerce payment, which the payer approves without a token.
+func WithPayer(ctx context.Context, msisdn string) context.Context {
+	return context.WithValue(ctx, payerKey{}, msisdn)
+}
+
+// payerFromContext returns the payer set with WithPayer, it is empty if none is set
+func payerFromContext(ctx context.Context) (string, error) {
+	msisdn, _ := ctx.Value(payerKey{}).(string)
+	if msisdn != "" && !msisdnPattern.MatchString(msisdn) {
+		return "", fmt.Errorf("invalid payer %q in context, it must be 8 to 15 digits", msisdn)
+	}
+
+	return msisdn, nil
+}
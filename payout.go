@@ -0,0 +1,122 @@
+package swish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type payoutStatusResponse struct {
+	// PaymentReference Payment reference, from the bank, of the payout. Only available if status is PAID.
+	PaymentReference string `json:"paymentReference"`
+
+	// PayoutInstructionUUID is the ID that the payout was created with
+	PayoutInstructionUUID string `json:"payoutInstructionUUID"`
+
+	// PayerPaymentReference Payment reference of the payer, which is the merchant that makes the payout
+	PayerPaymentReference string `json:"payerPaymentReference"`
+
+	// CallbackURL URL that Swish will use to notify caller about the outcome of the payout
+	CallbackURL string `json:"callbackUrl"`
+
+	// PayerAlias The Swish number of the merchant that makes the payout
+	PayerAlias string `json:"payerAlias"`
+
+	// PayeeAlias The registered cellphone number of the person that receives the payout
+	PayeeAlias string `json:"payeeAlias"`
+
+	// PayeeSSN The social security number of the person that receives the payout
+	PayeeSSN string `json:"payeeSSN"`
+
+	// Amount The amount of money that is paid out
+	Amount float64 `json:"amount"`
+
+	// Currency The currency of the payout. The only currently supported value is SEK
+	Currency string `json:"currency"`
+
+	// Message Merchant supplied message about the payout
+	Message string `json:"message"`
+
+	// PayoutType The type of payout, PAYOUT is the only supported value
+	PayoutType string `json:"payoutType"`
+
+	// Status The status of the payout. Possible values: CREATED, DEBITED, PAID, ERROR.
+	Status string `json:"status"`
+
+	// DateCreated The time and date that the payout was created.
+	DateCreated time.Time `json:"dateCreated"`
+
+	// DatePaid The time and date that the payout was paid. Only applicable if status was PAID.
+	DatePaid time.Time `json:"datePaid"`
+
+	// ErrorCode A code indicating what type of error occurred. Only applicable if status is ERROR.
+	ErrorCode string `json:"errorCode"`
+
+	// ErrorMessage A descriptive error message (in English) indicating what type of error occurred. Only applicable if
+	// status is ERROR.
+	ErrorMessage string `json:"errorMessage"`
+
+	// AdditionalInformation Additional information about the error. Only applicable if status is ERROR.
+	AdditionalInformation string `json:"additionalInformation"`
+
+	ResponseInfo
+}
+
+// UnmarshalJSON decodes a payout status and accepts all known Swish timestamp layouts in DateCreated and DatePaid
+func (r *payoutStatusResponse) UnmarshalJSON(data []byte) (err error) {
+	type plain payoutStatusResponse
+	return unmarshalWithSwishTimes(data, (*plain)(r), &r.DateCreated, &r.DatePaid)
+}
+
+// PayoutStatus use the location header of a created payout to get its status from Swish
+func (s *Swish) PayoutStatus(ctx context.Context, location string) (result payoutStatusResponse, err error) {
+	err = s.checkLocation(location)
+	if err != nil {
+		return
+	}
+
+	req, err := s.newRequest(ctx, getPayoutStatus, location, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	result.ResponseInfo = s.responseInfo(resp)
+
+	if resp.StatusCode == http.StatusNotFound {
+		var errCodes []errorResponse
+		errCodes, err = decodeErrors(resp.Body)
+		if err != nil {
+			return
+		}
+
+		for _, errCode := range errCodes {
+			result.ErrorCode = errCode.ErrorCode
+			result.ErrorMessage = errCode.ErrorMessage
+		}
+
+		return result, s.codesError(resp.StatusCode, errCodes, nil)
+	}
+
+	if !getPayoutStatus.ok(resp.StatusCode) {
+		return result, httpError(resp)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return
+}
+
+// DecodePayoutCallback reads the payout that Swish posts to the callback URL
+func DecodePayoutCallback(r *http.Request) (result payoutStatusResponse, err error) {
+	defer r.Body.Close()
+
+	err = json.NewDecoder(r.Body).Decode(&result)
+	return
+}
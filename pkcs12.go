@@ -0,0 +1,169 @@
+package swish
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrIncorrectPassphrase is returned when the passphrase does not decrypt the p12 certificate
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+// ErrInvalidCertificate is returned when the p12 certificate can not be decoded or does not hold a usable client
+// certificate
+var ErrInvalidCertificate = errors.New("invalid certificate data")
+
+// ValidatePassphrase checks that passphrase decrypts the p12 certificate and that it holds a usable client
+// certificate, without building a client, e.g. to check credentials as they are entered. The error matches
+// ErrIncorrectPassphrase or ErrInvalidCertificate.
+func ValidatePassphrase(cert []byte, passphrase string) error {
+	blocks, err := decodePKCS12(cert, passphrase)
+	if err != nil {
+		return err
+	}
+
+	err = validatePEMBlocks(blocks)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCertificate, err)
+	}
+
+	return nil
+}
+
+// decodePKCS12 decodes the p12 certificate into PEM blocks, telling a wrong passphrase apart from corrupt data. Both the
+// legacy RC2 and 3DES encryption and the AES encryption that OpenSSL 3 exports with by default are supported.
+func decodePKCS12(cert []byte, passphrase string) ([]*pem.Block, error) {
+	blocks, err := gopkcs12.ToPEM(cert, passphrase)
+	if errors.Is(err, gopkcs12.ErrIncorrectPassword) {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	var notImplemented gopkcs12.NotImplementedError
+	if errors.As(err, &notImplemented) {
+		return nil, fmt.Errorf("%w: %s, export the certificate again with openssl pkcs12 -export -legacy", ErrInvalidCertificate, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCertificate, err)
+	}
+
+	return blocks, nil
+}
+
+// EncodePKCS12 builds the p12 encoded certificate that Options.SSLCertificate expects from PEM encoded certificates
+// and an unencrypted PEM encoded private key. certPEM may hold the whole chain, the certificate that matches the key is
+// used as client certificate and the others are included as CA certificates.
+func EncodePKCS12(certPEM, keyPEM []byte, passphrase string) ([]byte, error) {
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaf *x509.Certificate
+	var caCerts []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if leaf == nil && publicKeyMatches(cert, key) {
+			leaf = cert
+			continue
+		}
+
+		caCerts = append(caCerts, cert)
+	}
+
+	if leaf == nil {
+		return nil, errors.New("no certificate matches the private key")
+	}
+
+	return gopkcs12.Encode(rand.Reader, key, leaf, caCerts, passphrase)
+}
+
+// parsePrivateKey reads the first private key of a PEM encoded key, in PKCS #1, PKCS #8 or SEC 1 form
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, keyPEM = pem.Decode(keyPEM)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if signer, ok := key.(crypto.Signer); ok {
+				return signer, nil
+			}
+		}
+
+		if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return key, nil
+		}
+	}
+}
+
+// validatePEMBlocks checks that the decoded blocks of a p12 certificate hold exactly one private key and at least one
+// certificate, and that the key belongs to the first certificate which is used as client certificate. It turns what
+// would be cryptic errors from tls.X509KeyPair into descriptive ones.
+func validatePEMBlocks(blocks []*pem.Block) error {
+	var keys, certs []*pem.Block
+	for _, block := range blocks {
+		switch {
+		case block.Type == "CERTIFICATE":
+			certs = append(certs, block)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			keys = append(keys, block)
+		}
+	}
+
+	if len(certs) == 0 {
+		return errors.New("p12 certificate holds no certificate")
+	}
+
+	if len(keys) != 1 {
+		return fmt.Errorf("p12 certificate must hold exactly one private key, found %d", len(keys))
+	}
+
+	cert, err := x509.ParseCertificate(certs[0].Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate in p12 certificate: %w", err)
+	}
+
+	key, err := parsePrivateKey(pem.EncodeToMemory(keys[0]))
+	if err != nil {
+		return fmt.Errorf("invalid private key in p12 certificate: %w", err)
+	}
+
+	if !publicKeyMatches(cert, key) {
+		return errors.New("private key does not match certificate")
+	}
+
+	return nil
+}
+
+// publicKeyMatches reports whether cert holds the public key of key
+func publicKeyMatches(cert *x509.Certificate, key crypto.Signer) bool {
+	pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(key.Public())
+}
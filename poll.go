@@ -0,0 +1,152 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStatusUnchanged is returned when the status did not change before the maximum wait time elapsed
+var ErrStatusUnchanged = errors.New("status unchanged")
+
+// ErrInvalidInterval is returned when the interval between polls is not positive
+var ErrInvalidInterval = errors.New("poll interval must be positive")
+
+// checkInterval returns an error wrapping ErrInvalidInterval if interval is not positive
+func checkInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInterval, interval)
+	}
+
+	return nil
+}
+
+// AwaitStatusChange polls the status of location until it differs from the status in from, or until maxWait or the
+// context has elapsed. The time between polls starts at interval and doubles after every poll. On timeout the last
+// status is returned together with an error wrapping ErrStatusUnchanged. An interval that is not positive is an error.
+func (s *Swish) AwaitStatusChange(ctx context.Context, location string, from string, interval, maxWait time.Duration) (result statusResponse, err error) {
+	err = checkInterval(interval)
+	if err != nil {
+		return
+	}
+
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+
+	wait := interval
+	for {
+		result, err = s.Status(ctx, location)
+		if err != nil {
+			return
+		}
+
+		if result.Status != from {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		case <-deadline.C:
+			timer.Stop()
+			return result, fmt.Errorf("%w: still %s after %s", ErrStatusUnchanged, from, maxWait)
+		case <-timer.C:
+		}
+
+		wait *= 2
+	}
+}
+
+// ErrPollingStopped is returned when polling is stopped through the stop channel
+var ErrPollingStopped = errors.New("polling stopped")
+
+// isFinal reports whether a payment or refund status will not change anymore
+func isFinal(status string) bool {
+	switch status {
+	case "PAID", "DECLINED", "ERROR", "CANCELLED":
+		return true
+	}
+
+	return false
+}
+
+// WaitForFinalStatus polls the status of location every interval until it is PAID, DECLINED, ERROR or CANCELLED,
+// or until the context is done. An interval that is not positive is an error.
+func (s *Swish) WaitForFinalStatus(ctx context.Context, location string, interval time.Duration) (statusResponse, error) {
+	return s.WaitForFinalStatusUntil(ctx, location, interval, nil)
+}
+
+// WaitForFinalStatusUntil works as WaitForFinalStatus, and also stops polling when stop is closed, e.g. when the user
+// navigated away. The last status is then returned with ErrPollingStopped.
+func (s *Swish) WaitForFinalStatusUntil(ctx context.Context, location string, interval time.Duration, stop <-chan struct{}) (result statusResponse, err error) {
+	return s.waitForFinalStatus(ctx, location, interval, stop, nil)
+}
+
+// WaitForFinalStatusFunc works as WaitForFinalStatus, and calls onUpdate with the first status and every status where
+// the status or the payment details changed, e.g. to update a UI. onUpdate is called synchronously between polls, and
+// a panic in it is recovered and logged.
+func (s *Swish) WaitForFinalStatusFunc(ctx context.Context, location string, interval time.Duration, onUpdate func(statusResponse)) (statusResponse, error) {
+	return s.waitForFinalStatus(ctx, location, interval, nil, onUpdate)
+}
+
+func (s *Swish) waitForFinalStatus(ctx context.Context, location string, interval time.Duration, stop <-chan struct{}, onUpdate func(statusResponse)) (result statusResponse, err error) {
+	err = checkInterval(interval)
+	if err != nil {
+		return
+	}
+
+	ctx, done := s.trackLocation(ctx, location)
+	defer done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous statusResponse
+	for polls := 0; ; polls++ {
+		result, err = s.Status(ctx, location)
+		if err != nil {
+			return
+		}
+
+		if onUpdate != nil && (polls == 0 || statusChanged(previous, result)) {
+			s.notify(onUpdate, result)
+		}
+
+		previous = result
+		if isFinal(result.Status) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-stop:
+			return result, ErrPollingStopped
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusChanged reports whether the status or the details that Swish fills in during a payment differ
+func statusChanged(a, b statusResponse) bool {
+	return a.Status != b.Status ||
+		a.PaymentReference != b.PaymentReference ||
+		a.PayerAlias != b.PayerAlias ||
+		a.Amount != b.Amount ||
+		!a.DatePaid.Equal(b.DatePaid) ||
+		a.ErrorCode != b.ErrorCode
+}
+
+// notify calls onUpdate with status and recovers a panic in it
+func (s *Swish) notify(onUpdate func(statusResponse), status statusResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logf("status update callback panicked: %v", r)
+		}
+	}()
+
+	onUpdate(status)
+}
@@ -0,0 +1,84 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PreflightError holds every check that failed in Preflight
+type PreflightError struct {
+	Errors []error
+}
+
+func (e *PreflightError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return "preflight failed: " + strings.Join(msgs, " | ")
+}
+
+// Ping checks that the configured endpoint is reachable, accepts the client certificate and is up. It asks for a
+// payment request that does not exist, so a 404 or a 2xx response means the endpoint works. Any other response is an
+// error, a MaintenanceError during a maintenance window.
+func (s *Swish) Ping(ctx context.Context) error {
+	req, err := s.newRequest(ctx, getPaymentRequest, getPaymentRequest.url(s.URL, strings.Repeat("0", 32)), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("certificate rejected by %s: %s", s.URL, resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return httpError(resp)
+	}
+
+	return nil
+}
+
+// Preflight checks that the client is ready to be used, that the certificate is valid and issued for the selected
+// environment, that the CA holds certificates and that the endpoint can be reached with the certificate. The
+// passphrase is already verified by New. All failed checks are returned in a *PreflightError.
+func (s *Swish) Preflight(ctx context.Context) error {
+	var errs []error
+
+	now := s.now()
+	if now.Before(s.snapshot.leaf.NotBefore) {
+		errs = append(errs, fmt.Errorf("certificate is not valid until %s", s.snapshot.leaf.NotBefore))
+	}
+
+	if now.After(s.snapshot.leaf.NotAfter) {
+		errs = append(errs, fmt.Errorf("certificate expired at %s", s.snapshot.leaf.NotAfter))
+	}
+
+	if err := checkEnvironment(s.snapshot.leaf, s.test); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(s.snapshot.caCerts) == 0 {
+		errs = append(errs, errors.New("CA holds no certificates"))
+	}
+
+	if err := s.Ping(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("ping failed: %w", err))
+	}
+
+	if len(errs) > 0 {
+		return &PreflightError{Errors: errs}
+	}
+
+	return nil
+}
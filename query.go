@@ -0,0 +1,70 @@
+package swish
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// queryFields are the fields of CreatePaymentRequestOptions that ToQuery and PaymentOptionsFromQuery carry, keyed by
+// their JSON name. InstructionUUID is left out so that a shared link never reuses an id, and PayerAlias, PayerSSN and
+// CallbackIdentifier are left out since they identify the payer or are a secret.
+func (opts *CreatePaymentRequestOptions) queryFields() map[string]*string {
+	return map[string]*string{
+		"callbackUrl":           &opts.CallbackURL,
+		"payeeAlias":            &opts.PayeeAlias,
+		"amount":                &opts.Amount,
+		"currency":              &opts.Currency,
+		"payeePaymentReference": &opts.PayeePaymentReference,
+		"payerAgeLimit":         &opts.PayerAgeLimit,
+		"message":               &opts.Message,
+	}
+}
+
+// sensitiveQueryFields are never read from or written to a query
+var sensitiveQueryFields = map[string]bool{
+	"payerAlias":         true,
+	"payerSSN":           true,
+	"callbackIdentifier": true,
+}
+
+// ToQuery returns the non-sensitive fields of the payment request as query values, keyed by their JSON name, for test
+// links and shared request configurations. InstructionUUID, PayerAlias, PayerSSN and CallbackIdentifier are left out,
+// as are empty fields.
+func (opts CreatePaymentRequestOptions) ToQuery() url.Values {
+	values := url.Values{}
+	for key, field := range opts.queryFields() {
+		if *field != "" {
+			values.Set(key, *field)
+		}
+	}
+
+	return values
+}
+
+// PaymentOptionsFromQuery reads payment request options that were written by ToQuery. It returns an error if a key is
+// unknown, given more than once or is one of the sensitive fields that ToQuery leaves out, so that a link can not
+// carry the personal details of a payer.
+func PaymentOptionsFromQuery(values url.Values) (opts CreatePaymentRequestOptions, err error) {
+	fields := opts.queryFields()
+	for key, v := range values {
+		if sensitiveQueryFields[key] {
+			err = fmt.Errorf("query field %q is sensitive and can not be read from a query", key)
+			return
+		}
+
+		field, ok := fields[key]
+		if !ok {
+			err = fmt.Errorf("unknown query field %q", key)
+			return
+		}
+
+		if len(v) != 1 {
+			err = fmt.Errorf("query field %q is given %d times", key, len(v))
+			return
+		}
+
+		*field = v[0]
+	}
+
+	return
+}
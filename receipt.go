@@ -0,0 +1,41 @@
+package swish
+
+import "time"
+
+// Receipt holds the details of a paid payment request for display, e.g. on a receipt page or in a PDF
+type Receipt struct {
+	// InstructionUUID is the ID that the payment request was created with
+	InstructionUUID string
+	// Amount is the paid amount with two decimals, e.g. "100.00"
+	Amount string
+	// Currency is the currency of the amount
+	Currency string
+	// Payee is the Swish number of the merchant that received the payment
+	Payee string
+	// Payer is the number of the payer masked with MaskMSISDN, it is empty if Swish did not give it
+	Payer string
+	// PaidAt is when the payment was made
+	PaidAt time.Time
+	// PaymentReference is the reference of the payment from the bank
+	PaymentReference string
+	// PayeePaymentReference is the reference of the merchant, e.g. the order id
+	PayeePaymentReference string
+	// Message is the message of the payment request
+	Message string
+}
+
+// Receipt returns the details of the payment for a receipt. It should only be used on a PAID status, other statuses
+// give a receipt without PaidAt and PaymentReference.
+func (r statusResponse) Receipt() Receipt {
+	return Receipt{
+		InstructionUUID:       r.InstructionUUID,
+		Amount:                formatMinorUnits(floatMinorUnits(r.Amount)),
+		Currency:              r.Currency,
+		Payee:                 r.PayeeAlias,
+		Payer:                 MaskMSISDN(r.PayerAlias),
+		PaidAt:                r.DatePaid,
+		PaymentReference:      r.PaymentReference,
+		PayeePaymentReference: r.PayeePaymentReference,
+		Message:               r.Message,
+	}
+}
@@ -0,0 +1,219 @@
+package swish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNotPaid is returned when a refund is requested for a payment that has not been paid
+var ErrNotPaid = errors.New("payment is not paid")
+
+// ErrRefundPayerMismatch is returned when the PayerAlias of a refund is not the PayeeAlias of the original payment
+var ErrRefundPayerMismatch = errors.New("refund payer alias does not match the payee alias of the payment")
+
+// ErrPaymentNotResolvable is returned by PaymentForRefund when the location of the refunded payment is not known
+var ErrPaymentNotResolvable = errors.New("payment of refund can not be resolved")
+
+// ErrRefundExceedsRemaining is matched by a RefundLimitError
+var ErrRefundExceedsRemaining = errors.New("refund exceeds the remaining amount of the payment")
+
+// RefundLimitError is returned by CreateRefund when Swish declines a refund with RF08, since its amount exceeds the
+// amount of the original payment minus any previous refunds. It matches ErrRefundExceedsRemaining with errors.Is, and
+// unwraps to the Error with the codes of the response. Swish does not tell the remaining amount, fetch the payment and
+// its refunds to find it.
+type RefundLimitError struct {
+	// Err is the error with the codes that Swish responded with
+	Err *Error
+	// Attempted is the amount of the declined refund
+	Attempted string
+	// OriginalPaymentReference is the payment that the refund was for
+	OriginalPaymentReference string
+}
+
+func (e *RefundLimitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RefundLimitError) Is(target error) bool {
+	return target == ErrRefundExceedsRemaining
+}
+
+func (e *RefundLimitError) Unwrap() error {
+	return e.Err
+}
+
+// refundError builds the error of a declined refund, a RefundLimitError if the amount exceeds what is left to refund
+func (s *Swish) refundError(errCodes []errorResponse, opts CreateRefundOptions) error {
+	for _, errCode := range errCodes {
+		if errCode.ErrorCode == string(ErrorCodeRF08) {
+			return &RefundLimitError{
+				Err:                      s.codesError(http.StatusUnprocessableEntity, errCodes, ErrRefundExceedsRemaining).(*Error),
+				Attempted:                opts.Amount,
+				OriginalPaymentReference: opts.OriginalPaymentReference,
+			}
+		}
+	}
+
+	return s.createError(errCodes)
+}
+
+// RefundType tells whether a refund is for the whole paid amount or a part of it
+type RefundType int
+
+const (
+	// RefundUnknown is a refund that was created without the original payment at hand, or whose amount is not within
+	// the paid amount
+	RefundUnknown RefundType = iota
+
+	// RefundFull is a refund of the whole paid amount
+	RefundFull
+
+	// RefundPartial is a refund of a part of the paid amount
+	RefundPartial
+)
+
+// RefundPayment refunds a payment that was fetched with Status. The OriginalPaymentReference, PayerAlias and Currency
+// of opts are taken from the payment when empty. A refund must be made from the Swish number that received the
+// payment, so an error wrapping ErrRefundPayerMismatch is returned without contacting Swish if PayerAlias differs. The
+// RefundType of the result compares the amount of the refund with the paid amount in minor units.
+func (s *Swish) RefundPayment(ctx context.Context, payment statusResponse, opts CreateRefundOptions) (result createRefundResponse, err error) {
+	if payment.Status != "PAID" {
+		return result, fmt.Errorf("%w: payment %s has status %s", ErrNotPaid, payment.InstructionUUID, payment.Status)
+	}
+
+	if opts.OriginalPaymentReference == "" {
+		opts.OriginalPaymentReference = payment.PaymentReference
+	}
+
+	if opts.Currency == "" {
+		opts.Currency = payment.Currency
+	}
+
+	if opts.PayerAlias == "" {
+		opts.PayerAlias = payment.PayeeAlias
+	}
+
+	if opts.PayerAlias != payment.PayeeAlias {
+		return result, fmt.Errorf("%w: refund from %s, payment to %s", ErrRefundPayerMismatch, opts.PayerAlias, payment.PayeeAlias)
+	}
+
+	opts.Amount, err = normalizeAmount(opts.Amount, s.rounding)
+	if err != nil {
+		return
+	}
+
+	refundType := RefundUnknown
+	if units, err := parseMinorUnits(opts.Amount); err == nil {
+		switch paid := floatMinorUnits(payment.Amount); {
+		case units == paid:
+			refundType = RefundFull
+		case units > 0 && units < paid:
+			refundType = RefundPartial
+		}
+	}
+
+	result, err = s.CreateRefund(ctx, opts)
+	if err != nil {
+		return
+	}
+
+	result.RefundType = refundType
+	return
+}
+
+// FullRefund refunds the whole paid amount of the payment at paymentLocation. The payment is fetched first, so that
+// the refund is made from the payee, for the amount and currency that was actually paid. An error wrapping ErrNotPaid
+// is returned if the payment is not PAID.
+func (s *Swish) FullRefund(ctx context.Context, paymentLocation string, callbackURL string) (result createRefundResponse, err error) {
+	payment, err := s.Status(ctx, paymentLocation)
+	if err != nil {
+		return
+	}
+
+	instructionUUID, err := newInstructionUUID()
+	if err != nil {
+		return
+	}
+
+	return s.RefundPayment(ctx, payment, CreateRefundOptions{
+		InstructionUUID:       instructionUUID,
+		CallbackURL:           callbackURL,
+		Amount:                formatMinorUnits(floatMinorUnits(payment.Amount)),
+		PayerPaymentReference: payment.PayeePaymentReference,
+	})
+}
+
+// DeriveRefundUUID returns an InstructionUUID for the refund with sequence number seq of a payment, so that retrying a
+// refund always uses the same identifier and Swish does not refund twice. It is the first 128 bits of a SHA-256 hash
+// of the upper case payment reference and seq, as 32 upper case hexadecimal characters. Different inputs collide with
+// negligible probability, but the identifier is only as unique as the pair, so never reuse a seq for another refund of
+// the same payment.
+func DeriveRefundUUID(originalPaymentReference string, seq int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", strings.ToUpper(originalPaymentReference), seq)))
+	return strings.ToUpper(hex.EncodeToString(sum[:16]))
+}
+
+type refundStatusResponse struct {
+	statusResponse
+
+	// OriginalPaymentReference Reference of the original payment that this refund is for
+	OriginalPaymentReference string `json:"originalPaymentReference"`
+
+	// PayerPaymentReference Payment reference of the merchant that made the refund
+	PayerPaymentReference string `json:"payerPaymentReference"`
+}
+
+// UnmarshalJSON decodes a refund status, the fields it shares with a payment request as by statusResponse
+func (r *refundStatusResponse) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, &r.statusResponse)
+	if err != nil {
+		return err
+	}
+
+	var refund struct {
+		OriginalPaymentReference string `json:"originalPaymentReference"`
+		PayerPaymentReference    string `json:"payerPaymentReference"`
+	}
+
+	err = json.Unmarshal(data, &refund)
+	r.OriginalPaymentReference = refund.OriginalPaymentReference
+	r.PayerPaymentReference = refund.PayerPaymentReference
+	return err
+}
+
+// RefundStatus gets the status of the refund at location like Status, including the reference of the payment it
+// refunds
+func (s *Swish) RefundStatus(ctx context.Context, location string) (result refundStatusResponse, err error) {
+	raw, status, err := s.StatusRaw(ctx, location)
+	if err != nil {
+		result.statusResponse = status
+		return
+	}
+
+	err = json.Unmarshal(raw, &result)
+	result.ResponseInfo = status.ResponseInfo
+	return
+}
+
+// PaymentForRefund gets the status of the payment that refund is for, e.g. for a report of payments and their refunds.
+// The original payment reference of a refund is the payment reference from the bank, which Swish can not look up a
+// payment request by. The location of the payment is instead taken from Options.LocationStore, where it is saved when
+// a status of the paid payment is fetched. An error wrapping ErrPaymentNotResolvable is returned if it is not there.
+func (s *Swish) PaymentForRefund(ctx context.Context, refund refundStatusResponse) (statusResponse, error) {
+	if s.locations == nil {
+		return statusResponse{}, fmt.Errorf("%w: Options.LocationStore is not set", ErrPaymentNotResolvable)
+	}
+
+	location, ok := s.locations.Load(paymentReferenceKey(refund.OriginalPaymentReference))
+	if !ok {
+		return statusResponse{}, fmt.Errorf("%w: no location stored for the original payment reference %s of refund %s", ErrPaymentNotResolvable, refund.OriginalPaymentReference, refund.InstructionUUID)
+	}
+
+	return s.Status(ctx, location)
+}
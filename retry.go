@@ -0,0 +1,69 @@
+package swish
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// MaxCodeRetries is how many times a request is retried on a retryable error code
+const MaxCodeRetries = 3
+
+// DefaultRetryBackoff is the wait before the first retry when Options.RetryBackoff is not set
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// retry calls send until it does not fail with a retryable error code, at most MaxCodeRetries extra times. Only V2
+// sends the InstructionUUID, with V1 every retry would create a new payment request or refund, so it is never
+// retried.
+func (s *Swish) retry(ctx context.Context, send func() error) error {
+	if s.apiVersion != V2 {
+		return send()
+	}
+
+	delay := s.retryBackoff
+	for retries := 0; ; retries++ {
+		err := send()
+		if retries == MaxCodeRetries || !s.retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(s.retryDelay(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+	}
+}
+
+// retryDelay is the wait before a retry with the given backoff, a random part of it if Options.RetryJitter is set
+func (s *Swish) retryDelay(backoff time.Duration) time.Duration {
+	if !s.retryJitter || backoff <= 0 {
+		return backoff
+	}
+
+	s.jitterMu.Lock()
+	defer s.jitterMu.Unlock()
+
+	return time.Duration(s.jitterRand.Int63n(int64(backoff)))
+}
+
+// retryable reports whether err is a 422 with an error code in Options.RetryableErrorCodes
+func (s *Swish) retryable(err error) bool {
+	var swishErr *Error
+	if len(s.retryCodes) == 0 || !errors.As(err, &swishErr) || swishErr.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+
+	for _, code := range swishErr.Codes {
+		if s.retryCodes[code.ErrorCode] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package swish
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldSchemas holds the documented constraints of request fields, keyed by their json name
+var fieldSchemas = map[string]map[string]interface{}{
+	"callbackUrl":              {"pattern": "^https://", "format": "uri"},
+	"payeeAlias":               {"pattern": "^[0-9]{8,15}$"},
+	"payerAlias":               {"pattern": "^[0-9]{8,15}$"},
+	"amount":                   {"pattern": `^[0-9]{1,12}(\.[0-9]{1,2})?$`},
+	"currency":                 {"enum": []string{"SEK"}},
+	"payeePaymentReference":    {"pattern": `^[a-zA-Z0-9\-_.+*/]{1,36}$`},
+	"payerPaymentReference":    {"pattern": `^[a-zA-Z0-9\-_.+*/]{1,36}$`},
+	"payerSSN":                 {"pattern": "^[0-9]{12}$"},
+	"payerAgeLimit":            {"pattern": "^[1-9][0-9]?$"},
+	"message":                  {"pattern": messagePattern, "maxLength": MaxMessageLength},
+	"callbackIdentifier":       {"pattern": "^[a-zA-Z0-9-]{32,36}$"},
+	"originalPaymentReference": {"minLength": 1},
+}
+
+// fieldPatterns are the compiled patterns of fieldSchemas, keyed by json name
+var fieldPatterns = compileFieldPatterns()
+
+// compileFieldPatterns compiles the pattern of every field in fieldSchemas
+func compileFieldPatterns() map[string]*regexp.Regexp {
+	patterns := make(map[string]*regexp.Regexp)
+	for name, schema := range fieldSchemas {
+		if pattern, ok := schema["pattern"].(string); ok {
+			patterns[name] = regexp.MustCompile(pattern)
+		}
+	}
+
+	return patterns
+}
+
+// requiredPaymentFields are the fields of CreatePaymentRequestOptions that must be set. The payee alias can be left
+// empty when it is taken from the client certificate.
+var requiredPaymentFields = []string{"callbackUrl", "amount", "currency"}
+
+// requiredRefundFields are the fields of CreateRefundOptions that must be set. The payer alias can be left empty when
+// it is taken from the client certificate.
+var requiredRefundFields = []string{"originalPaymentReference", "callbackUrl", "amount", "currency"}
+
+// OptionsSchema returns a JSON Schema describing CreatePaymentRequestOptions and CreateRefundOptions, with the
+// documented constraints and the required fields of each.
+func OptionsSchema() []byte {
+	schema := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"definitions": map[string]interface{}{
+			"CreatePaymentRequestOptions": structSchema(reflect.TypeOf(CreatePaymentRequestOptions{}), requiredPaymentFields),
+			"CreateRefundOptions":         structSchema(reflect.TypeOf(CreateRefundOptions{}), requiredRefundFields),
+		},
+	}
+
+	// Marshalling maps of strings can not fail, and keys are sorted which makes the output stable
+	b, _ := json.Marshal(schema)
+	return b
+}
+
+// validateFields checks the json string fields of the struct v against fieldSchemas, and that the fields in required are
+// set. Fields that are not set are otherwise not checked. The first field that fails is returned as an error.
+func validateFields(v interface{}, required []string) error {
+	isRequired := make(map[string]bool)
+	for _, name := range required {
+		isRequired[name] = true
+	}
+
+	value := reflect.ValueOf(v)
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" || name == "" || value.Field(i).Kind() != reflect.String {
+			continue
+		}
+
+		field := value.Field(i).String()
+		if field == "" {
+			if isRequired[name] {
+				return fmt.Errorf("%s is required", name)
+			}
+
+			continue
+		}
+
+		schema := fieldSchemas[name]
+		if pattern, ok := fieldPatterns[name]; ok && !pattern.MatchString(field) {
+			return fmt.Errorf("%s %q does not match %s", name, field, pattern)
+		}
+
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, field) {
+			return fmt.Errorf("%s %q must be one of %s", name, field, strings.Join(enum, ", "))
+		}
+
+		if max, ok := schema["maxLength"].(int); ok && utf8.RuneCountInString(field) > max {
+			return fmt.Errorf("%s %q is longer than %d characters", name, field, max)
+		}
+	}
+
+	return nil
+}
+
+// containsString reports whether s is in list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// structSchema describes the json fields of t, of which required must be set
+func structSchema(t reflect.Type, required []string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		if tag[0] == "-" || tag[0] == "" {
+			continue
+		}
+
+		property := map[string]interface{}{"type": "string"}
+		for k, v := range fieldSchemas[tag[0]] {
+			property[k] = v
+		}
+
+		properties[tag[0]] = property
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,103 @@
+package swish
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrNoPayoutSigningKey is returned when a payout is signed without Options.PayoutSigningKey
+var ErrNoPayoutSigningKey = errors.New("no payout signing key")
+
+// parsePayoutSigningKey reads the PEM encoded RSA key that signs payouts and checks that it can sign with hash,
+// SHA-512 is used if hash is zero. It returns no key if keyPEM is empty.
+func parsePayoutSigningKey(keyPEM []byte, hash crypto.Hash) (*rsa.PrivateKey, crypto.Hash, error) {
+	if len(keyPEM) == 0 {
+		return nil, hash, nil
+	}
+
+	if hash == 0 {
+		hash = crypto.SHA512
+	}
+
+	if !hash.Available() {
+		return nil, hash, fmt.Errorf("payout signature hash %s is not available", hash)
+	}
+
+	signer, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, hash, fmt.Errorf("invalid payout signing key: %w", err)
+	}
+
+	key, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, hash, errors.New("payout signing key must be an RSA key")
+	}
+
+	_, err = rsa.SignPKCS1v15(rand.Reader, key, hash, make([]byte, hash.Size()))
+	if err != nil {
+		return nil, hash, fmt.Errorf("payout signing key can not sign with %s: %w", hash, err)
+	}
+
+	return key, hash, nil
+}
+
+// VerifyPayoutSignature checks that signatureB64 is a base64 encoded SHA-512 RSA signature of payload made with the
+// key of cert, as Swish requires, e.g. to catch a signing error before Swish rejects the payout.
+func VerifyPayoutSignature(payload []byte, signatureB64 string, cert *x509.Certificate) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("payout signing certificate does not hold an RSA key")
+	}
+
+	return verifyPayoutSignature(payload, signatureB64, pub, crypto.SHA512)
+}
+
+// verifyPayoutSignature checks that signatureB64 is a base64 encoded RSA signature of payload made with the key of pub
+// and hash
+func verifyPayoutSignature(payload []byte, signatureB64 string, pub *rsa.PublicKey, hash crypto.Hash) error {
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("invalid payout signature: %w", err)
+	}
+
+	h := hash.New()
+	h.Write(payload)
+
+	err = rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature)
+	if err != nil {
+		return fmt.Errorf("payout signature does not match the payload: %w", err)
+	}
+
+	return nil
+}
+
+// SignPayout signs the json payload of a payout with the payout signing key, and returns the base64 encoded signature
+// that Swish expects. The signature is verified against the payload before it is returned. The payload must be sent
+// exactly as it was signed.
+func (s *Swish) SignPayout(payload []byte) (string, error) {
+	if s.payoutKey == nil {
+		return "", ErrNoPayoutSigningKey
+	}
+
+	h := s.payoutHash.New()
+	h.Write(payload)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.payoutKey, s.payoutHash, h.Sum(nil))
+	if err != nil {
+		return "", err
+	}
+
+	signatureB64 := base64.StdEncoding.EncodeToString(signature)
+	err = verifyPayoutSignature(payload, signatureB64, &s.payoutKey.PublicKey, s.payoutHash)
+	if err != nil {
+		return "", err
+	}
+
+	return signatureB64, nil
+}
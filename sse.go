@@ -0,0 +1,45 @@
+package swish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StatusSSE streams the status of location to a browser as Server-Sent Events. It polls like WaitForFinalStatusFunc
+// and writes the status as json in a data event for the first status and every change, until the status is final or
+// the context is done. Pass the context of the incoming request, so that polling stops when the browser disconnects.
+// The SSN of the payer is left out of the events.
+func (s *Swish) StatusSSE(ctx context.Context, w http.ResponseWriter, location string, interval time.Duration) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("response writer does not support flushing")
+	}
+
+	err := checkInterval(interval)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	_, err = s.waitForFinalStatus(ctx, location, interval, nil, func(status statusResponse) {
+		status.PayerSSN = ""
+		data, err := json.Marshal(status)
+		if err != nil {
+			s.logf("could not encode status event: %s", err)
+			return
+		}
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	})
+
+	return err
+}
@@ -0,0 +1,113 @@
+package swish
+
+// Status is the status of a payment request or refund
+type Status string
+
+const (
+	// StatusCreated is a request that is waiting for the payer
+	StatusCreated Status = "CREATED"
+
+	// StatusPaid is a request that has been paid
+	StatusPaid Status = "PAID"
+
+	// StatusDeclined is a request that the payer declined
+	StatusDeclined Status = "DECLINED"
+
+	// StatusError is a request that failed, see the error code for the reason
+	StatusError Status = "ERROR"
+
+	// StatusCancelled is a request that the merchant cancelled
+	StatusCancelled Status = "CANCELLED"
+)
+
+// transitions are the statuses that a payment request can move to from each status. A payment request is created as
+// CREATED, and moves from there to exactly one final status: PAID when the payer pays, DECLINED when the payer declines
+// or does not answer in time, ERROR when the payment fails, or CANCELLED when the merchant cancels it. A final status
+// never changes.
+var transitions = map[Status][]Status{
+	StatusCreated: {StatusPaid, StatusDeclined, StatusError, StatusCancelled},
+}
+
+// AllowedTransitions returns the statuses that a payment request with this status can move to, it is empty for a
+// final or unknown status
+func (s Status) AllowedTransitions() []Status {
+	return append([]Status(nil), transitions[s]...)
+}
+
+// CanTransitionTo reports whether a payment request with this status can move to next
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, status := range transitions[s] {
+		if status == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeclineReason is why a payment was declined
+type DeclineReason int
+
+const (
+	// ReasonUnknown is a decline without a known reason, Swish often gives none when the payer declines in the app
+	ReasonUnknown DeclineReason = iota
+
+	// ReasonAgeLimit is a payer that does not meet the PayerAgeLimit, error code VR01
+	ReasonAgeLimit
+
+	// ReasonSSNMismatch is a payer that is not enrolled with the PayerSSN of the request, error code VR02
+	ReasonSSNMismatch
+
+	// ReasonPayerCancelled is a payer that cancelled the BankID signing, error code BANKIDCL
+	ReasonPayerCancelled
+)
+
+// declineReasons maps the error codes that Swish may give with a decline to their reason
+var declineReasons = map[ErrorCode]DeclineReason{
+	ErrorCodeVR01:     ReasonAgeLimit,
+	ErrorCodeVR02:     ReasonSSNMismatch,
+	ErrorCodeBANKIDCL: ReasonPayerCancelled,
+}
+
+// declineReason returns the reason of a DECLINED status from its error code, it is ReasonUnknown for other statuses
+// and unknown codes.
+func declineReason(r statusResponse) DeclineReason {
+	if Status(r.Status) != StatusDeclined {
+		return ReasonUnknown
+	}
+
+	return declineReasons[ErrorCode(r.ErrorCode)]
+}
+
+// AmountMatches reports whether the amount equals expected, e.g. the total of the order. The amounts are compared in
+// minor units, so 100.1 matches "100.10". An invalid expected amount never matches. Combine it with
+// VerifyCallbackIdentifier and StatusMatches before acting on a callback.
+func (r statusResponse) AmountMatches(expected string) bool {
+	units, err := parseMinorUnits(expected)
+	return err == nil && units == floatMinorUnits(r.Amount)
+}
+
+// StatusMatches reports whether the status equals expected
+func (r statusResponse) StatusMatches(expected Status) bool {
+	return Status(r.Status) == expected
+}
+
+// Outcome reports whether the status is final, and whether it is a success, which only PAID is
+func (r statusResponse) Outcome() (final bool, success bool) {
+	return isFinal(r.Status), Status(r.Status) == StatusPaid
+}
+
+// ToCreateOptions returns the options to create a new payment request with the same payee, amount, currency, message,
+// reference and callback URL, e.g. to offer an expired m-commerce payment again. InstructionUUID is left empty so that
+// a new one is generated, and the payer and the CallbackIdentifier are not copied since they belong to the previous
+// request.
+func (r statusResponse) ToCreateOptions() CreatePaymentRequestOptions {
+	return CreatePaymentRequestOptions{
+		CallbackURL:           r.CallbackURL,
+		PayeeAlias:            r.PayeeAlias,
+		Amount:                formatMinorUnits(floatMinorUnits(r.Amount)),
+		Currency:              r.Currency,
+		PayeePaymentReference: r.PayeePaymentReference,
+		Message:               r.Message,
+	}
+}
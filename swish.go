@@ -3,15 +3,25 @@ package swish
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/pkcs12"
+	"io"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,8 +30,33 @@ type webServiceURL string
 const (
 	testURL webServiceURL = "https://mss.cpc.getswish.net"
 	prodURL webServiceURL = "https://cpc.getswish.net"
+	qrURL   webServiceURL = "https://mpc.getswish.net/qrg-swish"
 )
 
+// Endpoints are the base URLs of a Swish environment
+type Endpoints struct {
+	// API is the base URL of the merchant API used for payment requests and refunds
+	API string
+
+	// QR is the base URL of the QR code generator. Swish only hosts it in production, so it is shared by all
+	// environments.
+	QR string
+}
+
+// Environments returns the default endpoints keyed by environment name, "test" and "production"
+func Environments() map[string]Endpoints {
+	return map[string]Endpoints{
+		"test": {
+			API: string(testURL),
+			QR:  string(qrURL),
+		},
+		"production": {
+			API: string(prodURL),
+			QR:  string(qrURL),
+		},
+	}
+}
+
 const (
 	Certificate = "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tDQpNSUlEcnpDQ0FwZWdBd0lCQWdJUUNEdmdWcEJDUnJHaGRXckpXWkhIU2pBTkJna3Foa2lHOXcwQkFRVUZBREJoDQpNUXN3Q1FZRFZRUUdFd0pWVXpFVk1CTUdBMVVFQ2hNTVJHbG5hVU5sY25RZ1NXNWpNUmt3RndZRFZRUUxFeEIzDQpkM2N1WkdsbmFXTmxjblF1WTI5dE1TQXdIZ1lEVlFRREV4ZEVhV2RwUTJWeWRDQkhiRzlpWVd3Z1VtOXZkQ0JEDQpRVEFlRncwd05qRXhNVEF3TURBd01EQmFGdzB6TVRFeE1UQXdNREF3TURCYU1HRXhDekFKQmdOVkJBWVRBbFZUDQpNUlV3RXdZRFZRUUtFd3hFYVdkcFEyVnlkQ0JKYm1NeEdUQVhCZ05WQkFzVEVIZDNkeTVrYVdkcFkyVnlkQzVqDQpiMjB4SURBZUJnTlZCQU1URjBScFoybERaWEowSUVkc2IySmhiQ0JTYjI5MElFTkJNSUlCSWpBTkJna3Foa2lHDQo5dzBCQVFFRkFBT0NBUThBTUlJQkNnS0NBUUVBNGp2aEVYTGVxS1RUbzFlcVVLS1BDM2VReWFLbDdoTE9sbHNCDQpDU0RNQVpPblRqQzNVL2REeEdrQVY1M2lqU0xkaHdaQUFJRUp6czRiZzcvZnpUdHhSdUxXWnNjRnMzWW5Gbzk3DQpuaDZWZmU2M1NLTUkydGF2ZWd3NUJtVi9TbDBmdkJmNHE3N3VLTmQwZjNwNG1WbUZhRzVjSXpKTHYwN0E2RnB0DQo0M0MvZHhDLy9BSDJoZG1vUkJCWU1xbDFHTlhSb3I1SDRpZHE5Sm96K0VrSVlJdlVYN1E2aEwraHFrcE1mVDdQDQpUMTlzZGw2Z1N6ZVJudHdpNW0zT0ZCcU9hc3YremJNVVpCZkhXeW1lTXIveTd2clRDMExVcTdkQk10b00xTy80DQpnZFc3alZnL3RSdm9TU2lpY05veEJOMzNzaGJ5VEFwT0I2anRTajFldFgramtNT3ZKd0lEQVFBQm8yTXdZVEFPDQpCZ05WSFE4QkFmOEVCQU1DQVlZd0R3WURWUjBUQVFIL0JBVXdBd0VCL3pBZEJnTlZIUTRFRmdRVUE5NVFOVmJSDQpUTHRtOEtQaUd4dkRsN0k5MFZVd0h3WURWUjBqQkJnd0ZvQVVBOTVRTlZiUlRMdG04S1BpR3h2RGw3STkwVlV3DQpEUVlKS29aSWh2Y05BUUVGQlFBRGdnRUJBTXVjTjZwSUV4SUsrdDFFbkU5U3NQVGZyZ1QxZVhrSW95UVkvRXNyDQpoTUF0dWRYSC92VEJIMWpMdUcyY2VuVG5tQ21yRWJYamNLQ2h6VXlJbVpPTWtYRGlxdzhjdnBPcC8yUFY1QWRnDQowNk8vblZzSjhkV080MVAwam1QNlA2ZmJ0R2JmWW1iVzBXNUJqZkl0dGVwM1NwK2RXT0lyV2NCQUkrMHRLSUpGDQpQbmxVa2lhWTRJQklxRGZ2OE5aNVlCYmVyT2dPelc2c1JCYzRMMG5hNFVVK0tyazJVODg2VUFiM0x1akVWMGxzDQpZU0VZMVFTdGVEd3NPb0JycCt1dkZSVHAySW5CdVRoczRwRnNpdjlrdVhjbFZ6REFHeVNqNGR6cDMwZDh0YlFrDQpDQVV3N0MyOUM3OUZ2MUM1cWZQcm1BRVNyY2lJeHBnMFg0MEtQTWJwMVpXVmJkND0NCi0tLS0tRU5EIENFUlRJRklDQVRFLS0tLS0NCg=="
 )
@@ -37,32 +72,222 @@ type Options struct {
 	// Test indicates whether the http client will use the test environment endpoint and CA certificate
 	Test bool // enable test environment
 
-	// CA is base64 encoded string with your certificate authority
+	// CA is base64 encoded string with your certificate authority, it may contain several PEM encoded certificates
 	CA string
 
+	// InsecureSkipVerify turns off the verification of the server certificate against CA, e.g. behind a proxy that
+	// terminates TLS with a certificate of its own. The server certificate is verified by default.
+	InsecureSkipVerify bool
+
 	// Timeout in seconds for the http client
 	Timeout int // Client timeout in seconds
+
+	// APIVersion selects which version of the create payment request and create refund endpoints to use. Defaults to
+	// V2.
+	APIVersion APIVersion
+
+	// Logger receives diagnostic messages from the client, nothing is logged if it is nil
+	Logger *log.Logger
+
+	// Proxy returns the proxy to use for a request, defaults to http.ProxyFromEnvironment which reads the HTTPS_PROXY
+	// and NO_PROXY environment variables.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// DisableKeepAlives opens a new connection for every request. Reusing connections saves a TLS handshake per
+	// request, but idle connections may be silently dropped by load balancers in between, which makes the next request
+	// on that connection fail. Disable keep-alives if that happens in your network.
+	DisableKeepAlives bool
+
+	// StrictEnvironmentCheck makes New return an error if the client certificate is issued for another environment
+	// than the one selected with Test, which otherwise shows up as confusing handshake failures.
+	StrictEnvironmentCheck bool
+
+	// AllowedPayeeAliases are the Swish numbers registered for the certificate. If set, payment requests to any other
+	// PayeeAlias are rejected before they are sent.
+	AllowedPayeeAliases []string
+
+	// AmountRounding decides what happens to amounts with more than two decimals, defaults to RoundNone which rejects
+	// them.
+	AmountRounding AmountRounding
+
+	// CallbackURLCheck decides what happens when a callback URL on localhost or a private network is used against
+	// production, where Swish can not reach it. Defaults to CallbackURLWarn.
+	CallbackURLCheck CallbackURLCheck
+
+	// TLSHandshakeTimeout bounds the time of the TLS handshake, independently of Timeout. Defaults to
+	// DefaultTLSHandshakeTimeout.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds the wait for the response headers after the request is sent, independently of
+	// Timeout, which also covers reading the body. There is no separate limit if it is zero.
+	ResponseHeaderTimeout time.Duration
+
+	// Language is sent as the Accept-Language header of every request, e.g. "sv" or "en". Swish only documents
+	// error messages in English, so it may have no effect, nothing is sent if it is empty.
+	Language string
+
+	// MaxConcurrentRequests is the maximum number of requests in flight at the same time. Further requests wait for a
+	// slot until their context is done. There is no limit if it is zero.
+	MaxConcurrentRequests int
+
+	// LocationHosts are hosts besides the host of the endpoint that locations may point to, e.g. a proxy in front of
+	// Swish. A host may include the port.
+	LocationHosts []string
+
+	// DisableLocationCheck turns off the check that Status, PayoutStatus and CancelPayment does with ValidateLocation
+	// before following a location, and the check of the host of redirects. Only disable it if every location comes
+	// from a trusted source.
+	DisableLocationCheck bool
+
+	// DebugCurl logs an equivalent curl command to Logger for every request that fails or gets an error status, so it
+	// can be replayed against Swish. Authorization headers and the payerSSN are left out, and the client certificate
+	// is referred to by placeholder file names.
+	DebugCurl bool
+
+	// TokenValidity is how long Swish keeps an m-commerce payment request open, used by TimeUntilExpiry and ExpiresAt.
+	// Defaults to DefaultTokenValidity.
+	TokenValidity time.Duration
+
+	// ErrorFormatter builds the message of the error returned when Swish responds with error codes, e.g. to show a
+	// localized message to the user. The codes are still available in the ErrorCodes of the result. Defaults to the
+	// "[code] message | [code] message" format.
+	ErrorFormatter func([]ErrorResponse) string
+
+	// RetryableErrorCodes are error codes that Swish may respond with to a create payment request or refund, that are
+	// transient. The request is then sent again with the same InstructionUUID, at most MaxCodeRetries times. Other
+	// error codes fail immediately. V1 does not send the InstructionUUID, so nothing is retried with V1.
+	RetryableErrorCodes []string
+
+	// RetryBackoff is the wait before the first retry of a retryable error code, it doubles for every retry. Defaults
+	// to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+
+	// RetryJitter waits a random time between zero and the backoff before every retry (full jitter), so that many
+	// clients that fail at the same time do not retry at the same time.
+	RetryJitter bool
+
+	// NormalizePayerAlias passes the PayerAlias of a payment request through NormalizeMSISDN, so that numbers can be
+	// given as entered by the payer.
+	NormalizePayerAlias bool
+
+	// TrimFields removes leading and trailing whitespace from the payment references and the message of payment
+	// requests and refunds, e.g. from copy-pasted values. Spaces within them are kept. Trimming is done before any
+	// validation, so length checks apply to the trimmed values.
+	TrimFields bool
+
+	// PayoutSigningKey is the PEM encoded RSA private key of the signing certificate that is registered for payouts.
+	// It is needed to sign payouts, and is not the key of SSLCertificate.
+	PayoutSigningKey []byte
+
+	// PayoutSignatureHash is the hash that payouts are signed with. Defaults to SHA-512, which Swish requires today.
+	PayoutSignatureHash crypto.Hash
+
+	// MessageTemplate is the message of payment requests that are created without one, e.g. "Order
+	// {payeePaymentReference}". The {payeePaymentReference} placeholder is replaced by the reference of the request.
+	MessageTemplate string
+
+	// TruncateMessage cuts a message from MessageTemplate to MaxMessageLength characters, instead of failing the
+	// request when it is longer
+	TruncateMessage bool
+
+	// AliasFromCertificate fills in an empty PayeeAlias of a payment request and PayerAlias of a refund with the Swish
+	// number of the client certificate. They are sent empty otherwise.
+	AliasFromCertificate bool
+
+	// TLSPolicy is the preset of TLS versions and cipher suites that connections to Swish must use. Defaults to
+	// TLSModern.
+	TLSPolicy TLSPolicy
+
+	// LocationStore persists the location of every payment request and refund that is created, for
+	// StatusByStoredUUID. Nothing is stored if it is nil.
+	LocationStore LocationStore
 }
 
+// DefaultTLSHandshakeTimeout is the TLS handshake timeout used when Options.TLSHandshakeTimeout is not set
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// APIVersion is the version of the Swish API used when creating payment requests and refunds
+type APIVersion string
+
+const (
+	// V1 sends create requests as a POST to the resource collection and lets Swish assign the identifier. The
+	// InstructionUUID of the options is not used, the identifier can only be read from the returned Location.
+	V1 APIVersion = "v1"
+
+	// V2 sends create requests as a PUT with the InstructionUUID as identifier in the path. This is the default.
+	V2 APIVersion = "v2"
+)
+
 // Swish holds settings for this session
 type Swish struct {
-	client *http.Client
-	test   bool
+	client     *http.Client
+	test       bool
+	apiVersion APIVersion
+	logger     *log.Logger
+	snapshot   Snapshot
+	allowed    map[string]bool
+	rounding   AmountRounding
+	urlCheck   CallbackURLCheck
+	now        func() time.Time
+
+	locationHosts        []string
+	disableLocationCheck bool
+	tokenValidity        time.Duration
+	formatErrors         func([]errorResponse) string
+	retryCodes           map[string]bool
+	retryBackoff         time.Duration
+	retryJitter          bool
+	jitterMu             sync.Mutex
+	jitterRand           *mathrand.Rand
+	normalizePayer       bool
+	trimFields           bool
+	aliasFromCertificate bool
+	payoutKey            *rsa.PrivateKey
+	payoutHash           crypto.Hash
+	messageTemplate      string
+	truncateMessage      bool
+	locations            LocationStore
+
+	inflightMu  sync.Mutex
+	inflight    map[string]map[uint64]context.CancelFunc
+	inflightSeq uint64
 
 	// URL is the endpoint which we use to talk with BankID and can be replaced.
 	URL string
+
+	// QRURL is the endpoint of the Swish QR code generator and can be replaced.
+	QRURL string
+}
+
+// Snapshot holds the parsed certificate and CA of a client, so that more clients can be created without parsing them
+// again. It only lives in memory, the private key is never serialized.
+type Snapshot struct {
+	cert    tls.Certificate
+	leaf    *x509.Certificate
+	caPool  *x509.CertPool
+	caCerts []*x509.Certificate
 }
 
 // New creates a new client
 func New(opts Options) (*Swish, error) {
-	url := string(prodURL)
-	if opts.Test {
-		url = string(testURL)
+	snap, err := parseCertificates(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	blocks, err := pkcs12.ToPEM(opts.SSLCertificate, opts.Passphrase)
+	return NewFromSnapshot(snap, opts)
+}
+
+// parseCertificates decodes the p12 certificate and the CA of opts
+func parseCertificates(opts Options) (snap Snapshot, err error) {
+	blocks, err := decodePKCS12(opts.SSLCertificate, opts.Passphrase)
 	if err != nil {
-		return nil, err
+		return
+	}
+
+	err = validatePEMBlocks(blocks)
+	if err != nil {
+		return
 	}
 
 	var pemData []byte
@@ -70,39 +295,318 @@ func New(opts Options) (*Swish, error) {
 		pemData = append(pemData, pem.EncodeToMemory(b)...)
 	}
 
-	cert, err := tls.X509KeyPair(pemData, pemData)
+	snap.cert, err = tls.X509KeyPair(pemData, pemData)
 	if err != nil {
-		return nil, err
+		return
+	}
+
+	snap.leaf, err = x509.ParseCertificate(snap.cert.Certificate[0])
+	if err != nil {
+		return
 	}
 
 	ca, err := base64.StdEncoding.DecodeString(opts.CA)
 	if err != nil {
-		return nil, err
+		return
+	}
+
+	snap.caPool, snap.caCerts, err = loadCAPool(ca, opts.Logger)
+	return
+}
+
+// Snapshot returns the parsed certificate and CA of the client for use with NewFromSnapshot
+func (s *Swish) Snapshot() Snapshot {
+	return s.snapshot
+}
+
+// NewFromSnapshot creates a new client with the certificate and CA of a snapshot, which avoids parsing the p12
+// certificate again. SSLCertificate, Passphrase and CA of opts are ignored, all other options apply.
+func NewFromSnapshot(snap Snapshot, opts Options) (*Swish, error) {
+	if snap.leaf == nil || snap.caPool == nil {
+		return nil, errors.New("snapshot holds no certificate")
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(ca)
+	endpoint := string(prodURL)
+	if opts.Test {
+		endpoint = string(testURL)
+	}
+
+	if opts.StrictEnvironmentCheck {
+		err := checkEnvironment(snap.leaf, opts.Test)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	proxy := opts.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	handshakeTimeout := opts.TLSHandshakeTimeout
+	if handshakeTimeout == 0 {
+		handshakeTimeout = DefaultTLSHandshakeTimeout
+	}
 
 	transport := &http.Transport{
+		Proxy:                 proxy,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+		TLSHandshakeTimeout:   handshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
 		TLSClientConfig: &tls.Config{
-			Certificates:       []tls.Certificate{cert},
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{snap.cert},
+			RootCAs:            snap.caPool,
+			InsecureSkipVerify: opts.InsecureSkipVerify,
 		},
 	}
 
+	err := opts.TLSPolicy.apply(transport.TLSClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Test && opts.Logger != nil && !opts.InsecureSkipVerify {
+		// The chain is verified by verifyServerCertificate instead of the handshake, so that a failure is diagnosed
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		transport.TLSClientConfig.VerifyConnection = verifyServerCertificate(snap.caPool, snap.caCerts, opts.Logger)
+	}
+
+	// The curl command is logged from the innermost layer, so that it has every header that is sent
+	var roundTripper http.RoundTripper = transport
+	if opts.DebugCurl {
+		roundTripper = &curlTransport{next: roundTripper, logger: opts.Logger}
+	}
+
+	roundTripper = &gzipTransport{next: roundTripper}
+	if opts.Language != "" {
+		roundTripper = &headerTransport{next: roundTripper, header: http.Header{"Accept-Language": {opts.Language}}}
+	}
+
+	if opts.MaxConcurrentRequests > 0 {
+		roundTripper = &limitTransport{next: roundTripper, slots: make(chan struct{}, opts.MaxConcurrentRequests)}
+	}
+
 	client := &http.Client{
-		Transport: transport,
+		Transport: roundTripper,
 		Timeout:   time.Second * time.Duration(opts.Timeout),
 	}
 
-	return &Swish{
-		client: client,
-		URL:    url,
-		test:   opts.Test,
-	}, nil
+	apiVersion := opts.APIVersion
+	if apiVersion == "" {
+		apiVersion = V2
+	}
+
+	formatErrors := opts.ErrorFormatter
+	if formatErrors == nil {
+		formatErrors = joinErrors
+	}
+
+	var retryCodes map[string]bool
+	if len(opts.RetryableErrorCodes) > 0 {
+		retryCodes = make(map[string]bool)
+		for _, code := range opts.RetryableErrorCodes {
+			retryCodes[code] = true
+		}
+	}
+
+	retryBackoff := opts.RetryBackoff
+	if retryBackoff == 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+
+	tokenValidity := opts.TokenValidity
+	if tokenValidity == 0 {
+		tokenValidity = DefaultTokenValidity
+	}
+
+	payoutKey, payoutHash, err := parsePayoutSigningKey(opts.PayoutSigningKey, opts.PayoutSignatureHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed map[string]bool
+	if len(opts.AllowedPayeeAliases) > 0 {
+		allowed = make(map[string]bool)
+		for _, alias := range opts.AllowedPayeeAliases {
+			allowed[alias] = true
+		}
+	}
+
+	s := &Swish{
+		client:     client,
+		URL:        endpoint,
+		QRURL:      string(qrURL),
+		test:       opts.Test,
+		apiVersion: apiVersion,
+		logger:     opts.Logger,
+		snapshot:   snap,
+		allowed:    allowed,
+		rounding:   opts.AmountRounding,
+		urlCheck:   opts.CallbackURLCheck,
+		now:        time.Now,
+
+		locationHosts:        opts.LocationHosts,
+		disableLocationCheck: opts.DisableLocationCheck,
+		tokenValidity:        tokenValidity,
+		formatErrors:         formatErrors,
+		retryCodes:           retryCodes,
+		retryBackoff:         retryBackoff,
+		retryJitter:          opts.RetryJitter,
+		jitterRand:           mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+		normalizePayer:       opts.NormalizePayerAlias,
+		trimFields:           opts.TrimFields,
+		aliasFromCertificate: opts.AliasFromCertificate,
+		payoutKey:            payoutKey,
+		payoutHash:           payoutHash,
+		messageTemplate:      opts.MessageTemplate,
+		truncateMessage:      opts.TruncateMessage,
+		locations:            opts.LocationStore,
+	}
+
+	client.CheckRedirect = s.checkRedirect
+	return s, nil
+}
+
+// loadCAPool adds every certificate in the PEM encoded bundle to a pool and returns the certificates that was added,
+// duplicates are skipped with a warning. It is an error if the bundle holds no certificates.
+func loadCAPool(bundle []byte, logger *log.Logger) (*x509.CertPool, []*x509.Certificate, error) {
+	pool := x509.NewCertPool()
+	seen := make(map[string]bool)
+	var added []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse CA certificate: %w", err)
+		}
+
+		if seen[string(cert.Raw)] {
+			logf(logger, "skipping duplicate CA certificate %q", cert.Subject.CommonName)
+			continue
+		}
+
+		seen[string(cert.Raw)] = true
+		pool.AddCert(cert)
+		added = append(added, cert)
+	}
+
+	if len(added) == 0 {
+		return nil, nil, errors.New("no certificates found in CA")
+	}
+
+	logf(logger, "added %d CA certificates", len(added))
+	return pool, added, nil
+}
+
+// requestIDHeaders are the headers in which a request id may be returned
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id", "X-Correlation-Id"}
+
+// requestID returns the request id of a response, or an empty string if there is none
+func (s *Swish) requestID(resp *http.Response) string {
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			s.logf("%s %s responded %s with request id %s", resp.Request.Method, resp.Request.URL, resp.Status, id)
+			return id
+		}
+	}
+
+	return ""
+}
+
+// ResponseInfo describes the http response of a request to Swish, for logs and support cases
+type ResponseInfo struct {
+	// RequestID is the id that Swish assigned to the request, quote it when contacting Swish support
+	RequestID string `json:"-"`
+	// RequestURL is the URL that the request was sent to
+	RequestURL string `json:"-"`
+	// Proto is the http protocol of the response, e.g. "HTTP/2.0"
+	Proto string `json:"-"`
+	// TLSVersion is the TLS version of the connection, e.g. tls.VersionTLS12, it is zero without TLS
+	TLSVersion uint16 `json:"-"`
+	// TLSCipherSuite is the cipher suite of the connection, tls.CipherSuiteName gives its name
+	TLSCipherSuite uint16 `json:"-"`
+}
+
+// responseInfo returns the ResponseInfo of a response
+func (s *Swish) responseInfo(resp *http.Response) ResponseInfo {
+	info := ResponseInfo{
+		RequestID:  s.requestID(resp),
+		RequestURL: resp.Request.URL.String(),
+		Proto:      resp.Proto,
+	}
+
+	if resp.TLS != nil {
+		info.TLSVersion, info.TLSCipherSuite = resp.TLS.Version, resp.TLS.CipherSuite
+	}
+
+	return info
+}
+
+// logf writes a message to logger if it is configured
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
+
+// logf writes a message to the configured logger
+func (s *Swish) logf(format string, v ...interface{}) {
+	logf(s.logger, format, v...)
+}
+
+// checkRedirect stops the client from following redirects of requests that change state. Go would follow a 302 on a
+// PUT or POST with a GET, which hides the real outcome of the request, so the redirect response is returned as is.
+// Redirects of GET requests are only followed to the endpoint or Options.LocationHosts, as locations are, unless
+// Options.DisableLocationCheck is set.
+func (s *Swish) checkRedirect(req *http.Request, via []*http.Request) error {
+	if via[0].Method != "GET" {
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if s.disableLocationCheck {
+		return nil
+	}
+
+	ok, err := s.atEndpoint(req.URL)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("redirect to %q is not at the endpoint %s", req.URL, s.URL)
+	}
+
+	return nil
 }
 
+// createRequest builds the request that creates a resource, with the v1 or v2 endpoint according to the configured
+// API version.
+func (s *Swish) createRequest(ctx context.Context, v1, v2 endpointSpec, instructionUUID string, body []byte) (*http.Request, endpointSpec, error) {
+	if s.apiVersion == V1 {
+		req, err := s.newRequest(ctx, v1, v1.url(s.URL), body)
+		return req, v1, err
+	}
+
+	req, err := s.newRequest(ctx, v2, v2.url(s.URL, instructionUUID), body)
+	return req, v2, err
+}
+
+// ErrorResponse is an error code that Swish responds with
+type ErrorResponse = errorResponse
+
 type errorResponse struct {
 	// ErrorCode is the short code for the error
 	ErrorCode string `json:"errorCode"`
@@ -112,16 +616,117 @@ type errorResponse struct {
 	AdditionalInformation string `json:"additionalInformation"`
 }
 
+// decodeErrors reads the error codes of an error response. Swish usually responds with an array of errors, but some
+// responses hold a single error object which is returned as a slice with one element, and some environments wrap the
+// array in an object as {"errors": [...]}.
+func decodeErrors(r io.Reader) ([]errorResponse, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var errCodes []errorResponse
+	err = json.Unmarshal(body, &errCodes)
+	if err == nil {
+		return errCodes, nil
+	}
+
+	var object struct {
+		errorResponse
+		Errors []errorResponse `json:"errors"`
+	}
+
+	if json.Unmarshal(body, &object) != nil {
+		return nil, err
+	}
+
+	if object.Errors != nil {
+		return object.Errors, nil
+	}
+
+	return []errorResponse{object.errorResponse}, nil
+}
+
+// forbiddenErrors reads the error codes of a 403 response. A 403 can mean other problems such as a revoked certificate,
+// so the body is used when it holds errors. Swish usually leaves the body empty when the alias is not the Swish number
+// of the certificate, which is reported as PA01.
+func forbiddenErrors(r io.Reader) []errorResponse {
+	errCodes, err := decodeErrors(r)
+	if err == nil && len(errCodes) > 0 && errCodes[0].ErrorCode != "" {
+		return errCodes
+	}
+
+	return []errorResponse{{
+		ErrorCode:    string(ErrorCodePA01),
+		ErrorMessage: errorCodeCatalog[ErrorCodePA01],
+	}}
+}
+
+// ErrDuplicateInstruction is returned when the InstructionUUID was already used for another request. Fetch the status
+// of the existing request, or create the request with a new InstructionUUID.
+var ErrDuplicateInstruction = errors.New("instruction uuid is already used")
+
+// createError builds the error of a create request that Swish rejected with error codes
+func (s *Swish) createError(errCodes []errorResponse) error {
+	for _, errCode := range errCodes {
+		if errCode.ErrorCode == string(ErrorCodeRP09) {
+			return s.codesError(http.StatusUnprocessableEntity, errCodes, ErrDuplicateInstruction)
+		}
+	}
+
+	return s.codesError(http.StatusUnprocessableEntity, errCodes, nil)
+}
+
+// joinErrors formats error codes from Swish as a single error string, e.g. "[RP01] Missing Merchant Swish Number"
+func joinErrors(errCodes []errorResponse) string {
+	var errs string
+	for _, errCode := range errCodes {
+		if len(errs) > 0 {
+			errs += " | "
+		}
+		errs += fmt.Sprintf("[%s] %s", errCode.ErrorCode, errCode.ErrorMessage)
+	}
+
+	return errs
+}
+
+// formatInstructionUUID formats an identifier such as d2eb91f4-f3a7-4088-970f-a108b58bf8d9 to the 32 upper case
+// hexadecimal characters that Swish expects, e.g. D2EB91F4F3A74088970FA108B58BF8D9.
+func formatInstructionUUID(instructionUUID string) (string, error) {
+	id := strings.ToUpper(strings.ReplaceAll(instructionUUID, "-", ""))
+	if len(id) != 32 {
+		return "", fmt.Errorf("instruction uuid %q must be 32 hexadecimal characters", instructionUUID)
+	}
+
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", fmt.Errorf("instruction uuid %q must be 32 hexadecimal characters", instructionUUID)
+	}
+
+	return id, nil
+}
+
+// newInstructionUUID generates a random identifier in the format Swish expects
+func newInstructionUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(hex.EncodeToString(b)), nil
+}
+
 // CreatePaymentRequestOptions for the create payment request
 type CreatePaymentRequestOptions struct {
-	// Required: The identifier of the payment request to be saved. Example 11A86BE70EA346E4B1C39C874173F088 or d2eb91f4-f3a7-4088-970f-a108b58bf8d9
-	// The endpoint will format the string to fit Swish specification.
+	// Optional: The identifier of the payment request to be saved. Example 11A86BE70EA346E4B1C39C874173F088 or d2eb91f4-f3a7-4088-970f-a108b58bf8d9
+	// The endpoint will format the string to fit Swish specification. One is generated if it is left empty, with V1
+	// it is only used locally and not returned, as Swish assigns the identifier.
 	InstructionUUID string `json:"-"`
 
 	// Required: The endpoint Swish will call on with payment status updates, you need to receive data on this endpoint
 	CallbackURL string `json:"callbackUrl"`
 
-	// Required: The phone number that will receive the payment. Format E.164 except the plus ("+") symbol.
+	// Required: The phone number that will receive the payment. Format E.164 except the plus ("+") symbol. The Swish
+	// number of the client certificate is used if it is empty and Options.AliasFromCertificate is set.
 	PayeeAlias string `json:"payeeAlias"`
 
 	// Required: The amount that is charged with a float value. Example "100.01"
@@ -151,67 +756,261 @@ type CreatePaymentRequestOptions struct {
 	// Optional: Merchant supplied message about the payment/order. Max 50 chars. Allowed characters are the letters
 	// a-ö, A-Ö, the numbers 0-9 and the special characters :;.,?!()”.
 	Message string `json:"message,omitempty"`
+
+	// Optional: CallbackIdentifier is sent back by Swish in the callback so that the callback can be verified. It must
+	// be between 32 and 36 characters and may only contain a-z A-Z 0-9 and -.
+	CallbackIdentifier string `json:"callbackIdentifier,omitempty"`
+}
+
+// MarshalRequest returns the JSON body that is sent to Swish for the payment request. Fields are always in the same
+// order, so the bytes can be signed, hashed or stored. CreatePaymentRequest may fill in an empty PayeeAlias and rounds the
+// Amount according to Options.AmountRounding before marshalling, set them to get the exact bytes that are sent.
+func (opts CreatePaymentRequestOptions) MarshalRequest() ([]byte, error) {
+	return json.Marshal(opts)
 }
 
 type createPaymentRequestResponse struct {
+	// InstructionUUID is the identifier that the payment request was created with
+	InstructionUUID string
 	// Location is an URL that you use as GET to retrieve the status of the payment request
 	Location string
 	// PaymentRequestToken is returned when creating an m-commerce payment request. The token to use when opening the
 	// Swish app.
 	PaymentRequestToken string
+	// TokenCreated is the time when the PaymentRequestToken was received, it is zero if no token was returned
+	TokenCreated time.Time
+	// Flow is ECommerce if the payment request was created with a PayerAlias, otherwise MCommerce
+	Flow Flow
+	// AwaitingPayer is set for an e-commerce payment request, which Swish has sent to the app of the payer. There is
+	// no token to open the app with, the next step is to wait for the callback or poll Status.
+	AwaitingPayer bool
 	// ErrorCodes returns error codes
 	ErrorCodes []errorResponse
+	ResponseInfo
+
+	// tokenValidity is the Options.TokenValidity of the client that created the payment request
+	tokenValidity time.Duration
+}
+
+// DefaultTokenValidity is how long a PaymentRequestToken is valid when Options.TokenValidity is not set. Swish expires
+// m-commerce payment requests that has not been opened in the app after three minutes.
+const DefaultTokenValidity = 3 * time.Minute
+
+// ExpiresAt returns the time when the PaymentRequestToken expires given its validity, the Options.TokenValidity of the
+// client is used if validity is zero. A zero time is returned if no token was received.
+func (r createPaymentRequestResponse) ExpiresAt(validity time.Duration) time.Time {
+	if r.TokenCreated.IsZero() {
+		return time.Time{}
+	}
+
+	if validity == 0 {
+		validity = r.tokenValidity
+	}
+
+	if validity == 0 {
+		validity = DefaultTokenValidity
+	}
+
+	return r.TokenCreated.Add(validity)
+}
+
+// TimeUntilExpiry returns how long a payment request created at createdAt is left before Swish expires it, according
+// to Options.TokenValidity. It is zero once the payment request has expired.
+func (s *Swish) TimeUntilExpiry(createdAt time.Time) time.Duration {
+	left := createdAt.Add(s.tokenValidity).Sub(s.now())
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+// IsLikelyExpired reports whether a payment request created at createdAt has passed its validity. It is an estimate
+// from the local clock, only the status from Swish is authoritative.
+func (s *Swish) IsLikelyExpired(createdAt time.Time) bool {
+	return s.TimeUntilExpiry(createdAt) == 0
+}
+
+// ErrPayeeAliasNotAllowed is returned when the PayeeAlias of a payment request is not in Options.AllowedPayeeAliases
+var ErrPayeeAliasNotAllowed = errors.New("payee alias is not allowed")
+
+// CreatePaymentRequest sends a payment request to Swish to create a payment, using the v2 endpoint unless V1 is
+// configured in Options.APIVersion. An InstructionUUID is generated if neither opts nor WithInstructionUUID sets one.
+func (s *Swish) CreatePaymentRequest(ctx context.Context, opts CreatePaymentRequestOptions, reqOpts ...RequestOption) (result createPaymentRequestResponse, err error) {
+	opts, err = s.preparePaymentRequest(ctx, opts)
+	if err != nil {
+		return
+	}
+
+	id := newRequestOptions(reqOpts).instructionUUID
+	if id == "" {
+		id = opts.InstructionUUID
+	}
+
+	if id == "" {
+		opts.InstructionUUID, err = newInstructionUUID()
+	} else {
+		opts.InstructionUUID, err = formatInstructionUUID(id)
+	}
+
+	if err != nil {
+		return
+	}
+
+	body, err := opts.MarshalRequest()
+	if err != nil {
+		return
+	}
+
+	result, err = s.sendPaymentRequest(ctx, opts.InstructionUUID, body)
+	if id == "" && s.apiVersion == V1 {
+		// Swish never received the generated identifier
+		result.InstructionUUID = ""
+	}
+
+	if err != nil {
+		return
+	}
+
+	result.Flow, result.AwaitingPayer = paymentFlow(opts.PayerAlias, result.PaymentRequestToken)
+	return
 }
 
-// CreatePaymentRequest sends a v2 payment request to Swish to create a payment
-func (s *Swish) CreatePaymentRequest(ctx context.Context, opts CreatePaymentRequestOptions) (result createPaymentRequestResponse, err error) {
-	body, err := json.Marshal(opts)
+// preparePaymentRequest validates opts and fills in the defaults of the client, as done before a payment request is
+// sent
+func (s *Swish) preparePaymentRequest(ctx context.Context, opts CreatePaymentRequestOptions) (_ CreatePaymentRequestOptions, err error) {
+	if s.trimFields {
+		opts.trimSpace()
+	}
+
+	err = s.applyMessageTemplate(&opts)
+	if err != nil {
+		return
+	}
+
+	err = validateCallbackIdentifier(opts.CallbackIdentifier)
 	if err != nil {
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/swish-cpcapi/api/v2/paymentrequests/%s", s.URL, opts.InstructionUUID), bytes.NewBuffer(body))
+	err = s.checkCallbackURL(opts.CallbackURL)
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	if opts.PayerAlias == "" {
+		opts.PayerAlias, err = payerFromContext(ctx)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.normalizePayer && opts.PayerAlias != "" {
+		opts.PayerAlias, err = NormalizeMSISDN(opts.PayerAlias)
+		if err != nil {
+			return
+		}
+	}
+
+	opts.PayeeAlias = s.merchantAlias(ctx, opts.PayeeAlias)
+	opts.Amount, err = normalizeAmount(opts.Amount, s.rounding)
+	if err != nil {
+		return
+	}
+
+	if s.allowed != nil && !s.allowed[opts.PayeeAlias] {
+		return opts, fmt.Errorf("%w: %s", ErrPayeeAliasNotAllowed, opts.PayeeAlias)
+	}
+
+	return opts, nil
+}
+
+// CreatePaymentRequestRaw sends body as is to create a payment request with the given InstructionUUID, e.g. to replay
+// a stored request. The body must be json, it is not validated further. The response is handled as by
+// CreatePaymentRequest.
+func (s *Swish) CreatePaymentRequestRaw(ctx context.Context, instructionUUID string, body []byte) (createPaymentRequestResponse, error) {
+	if !json.Valid(body) {
+		return createPaymentRequestResponse{}, errors.New("body is not valid json")
+	}
+
+	if s.apiVersion != V1 {
+		id, err := formatInstructionUUID(instructionUUID)
+		if err != nil {
+			return createPaymentRequestResponse{}, err
+		}
+
+		instructionUUID = id
+	}
+
+	return s.sendPaymentRequest(ctx, instructionUUID, body)
+}
 
-	resp, err := s.client.Do(req)
+// sendPaymentRequest sends the body of a payment request, and sends it again while Swish responds with a retryable
+// error code
+func (s *Swish) sendPaymentRequest(ctx context.Context, instructionUUID string, body []byte) (result createPaymentRequestResponse, err error) {
+	ctx, done := s.track(ctx, instructionUUID)
+	defer done()
+
+	err = s.retry(ctx, func() (err error) {
+		result, err = s.sendPaymentRequestOnce(ctx, instructionUUID, body)
+		return
+	})
+
+	return
+}
+
+// sendPaymentRequestOnce sends the body of a payment request and handles the response
+func (s *Swish) sendPaymentRequestOnce(ctx context.Context, instructionUUID string, body []byte) (result createPaymentRequestResponse, err error) {
+	result.InstructionUUID = instructionUUID
+
+	req, endpoint, err := s.createRequest(ctx, createPaymentRequestV1, createPaymentRequestV2, instructionUUID, body)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
 	if err != nil {
 		return
 	}
 
 	defer resp.Body.Close()
 
+	result.ResponseInfo = s.responseInfo(resp)
+
 	if resp.StatusCode == http.StatusUnprocessableEntity {
-		err = json.NewDecoder(resp.Body).Decode(&result.ErrorCodes)
+		result.ErrorCodes, err = decodeErrors(resp.Body)
 		if err != nil {
 			return
 		}
 
-		var errs string
-		for _, errCode := range result.ErrorCodes {
-			if len(errs) > 0 {
-				errs += " | "
-			}
-			errs += fmt.Sprintf("[%s] %s", errCode.ErrorCode, errCode.ErrorMessage)
-		}
+		return result, s.createError(result.ErrorCodes)
+	}
 
-		return result, errors.New(errs)
+	if resp.StatusCode == http.StatusConflict {
+		result.ErrorCodes, _ = decodeErrors(resp.Body)
+		return result, &Error{StatusCode: resp.StatusCode, Codes: result.ErrorCodes, message: fmt.Sprintf("%s: %s", ErrDuplicateInstruction, instructionUUID), err: ErrDuplicateInstruction}
 	}
 
 	if resp.StatusCode == http.StatusForbidden {
-		result.ErrorCodes = append(result.ErrorCodes, errorResponse{
-			ErrorCode:             "PA01",
-			ErrorMessage:          "The payeeAlias in the payment request object is not the same as merchant’s Swish number",
-			AdditionalInformation: "",
-		})
-		return result, errors.New("[PA01] The payeeAlias in the payment request object is not the same as merchant’s Swish number")
+		result.ErrorCodes = forbiddenErrors(resp.Body)
+		return result, s.codesError(resp.StatusCode, result.ErrorCodes, nil)
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return result, httpError(resp)
+	}
+
+	if !endpoint.ok(resp.StatusCode) {
+		return result, httpError(resp)
 	}
 
 	result.Location = resp.Header.Get("Location")
+	s.saveLocation(instructionUUID, result.Location)
 	result.PaymentRequestToken = resp.Header.Get("Paymentrequesttoken")
+	if result.PaymentRequestToken != "" {
+		result.TokenCreated = s.now()
+		result.tokenValidity = s.tokenValidity
+	}
 
 	return
 }
@@ -275,47 +1074,87 @@ type statusResponse struct {
 
 	// AdditionalInformation Additional information about the error. Only applicable if status is ERROR.
 	AdditionalInformation string `json:"additionalInformation"`
+
+	// CallbackIdentifier The identifier that was given when the request was created. It is only set by DecodeCallback.
+	CallbackIdentifier string `json:"callbackIdentifier,omitempty"`
+
+	ResponseInfo
+
+	// DeclineReason is why the payment was declined, it is only set when Status is DECLINED
+	DeclineReason DeclineReason `json:"-"`
 }
 
 // Status use the location header from other endpoints to get status from Swish
 func (s *Swish) Status(ctx context.Context, Location string) (result statusResponse, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", Location, nil)
+	_, result, err = s.StatusRaw(ctx, Location)
+	return
+}
+
+// StatusRaw gets the status like Status, and also returns the body exactly as Swish sent it, e.g. for audit storage.
+// The status is decoded from the returned body.
+func (s *Swish) StatusRaw(ctx context.Context, location string) (raw []byte, result statusResponse, err error) {
+	err = s.checkLocation(location)
 	if err != nil {
 		return
 	}
 
-	resp, err := s.client.Do(req)
+	ctx, done := s.trackLocation(ctx, location)
+	defer done()
+
+	req, err := s.newRequest(ctx, getStatus, location, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+
+	result.ResponseInfo = s.responseInfo(resp)
+
+	raw, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
 
+	// httpError reads the body again to recognize a maintenance window
+	resp.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
 	if resp.StatusCode == http.StatusNotFound {
 		var errCodes []errorResponse
-		err = json.NewDecoder(resp.Body).Decode(&errCodes)
+		errCodes, err = decodeErrors(bytes.NewReader(raw))
 		if err != nil {
 			return
 		}
 
-		var errs string
 		for _, errCode := range errCodes {
-			if len(errs) > 0 {
-				errs += " | "
-			}
-			errs += fmt.Sprintf("[%s] %s", errCode.ErrorCode, errCode.ErrorMessage)
 			result.ErrorCode = errCode.ErrorCode
 			result.ErrorMessage = errCode.ErrorMessage
 		}
 
-		return result, errors.New(errs)
+		return raw, result, s.codesError(resp.StatusCode, errCodes, nil)
+	}
+
+	if !getStatus.ok(resp.StatusCode) {
+		return raw, result, httpError(resp)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		return
+	}
+
+	s.savePaymentReference(location, result.PaymentReference)
 	return
 }
 
 // CreateRefundOptions for create refund
 type CreateRefundOptions struct {
 	// Required: InstructionUUID The ID for this refund, should be different from create payment request InstructionUUID
+	// The endpoint will format the string to fit Swish specification. It is not sent with V1.
 	InstructionUUID string `json:"-"`
 
 	// Required: OriginalPaymentReference Reference of the original payment that this refund is for.
@@ -325,7 +1164,8 @@ type CreateRefundOptions struct {
 	// use HTTPS.
 	CallbackURL string `json:"callbackUrl"`
 
-	// Required: PayerAlias The Swish number of the merchant that makes the refund payment.
+	// Required: PayerAlias The Swish number of the merchant that makes the refund payment. The Swish number of the
+	// client certificate is used if it is empty and Options.AliasFromCertificate is set.
 	PayerAlias string `json:"payerAlias"`
 
 	// Required: Amount The amount of money to refund. The amount cannot be less than 0.01 SEK and not more than
@@ -342,6 +1182,17 @@ type CreateRefundOptions struct {
 	// Optional: Merchant supplied message about the refund. Max 50 chars. Allowed characters are the letters a-ö, A-Ö,
 	// the numbers 0-9 and the special characters :;.,?!()”.
 	Message string `json:"message"`
+
+	// Optional: CallbackIdentifier is sent back by Swish in the callback so that the callback can be verified. It must
+	// be between 32 and 36 characters and may only contain a-z A-Z 0-9 and -.
+	CallbackIdentifier string `json:"callbackIdentifier,omitempty"`
+}
+
+// MarshalRequest returns the JSON body that is sent to Swish for the refund. Fields are always in the same order, so
+// the bytes can be signed, hashed or stored. CreateRefund may fill in an empty PayerAlias and rounds the Amount according
+// to Options.AmountRounding before marshalling, set them to get the exact bytes that are sent.
+func (opts CreateRefundOptions) MarshalRequest() ([]byte, error) {
+	return json.Marshal(opts)
 }
 
 type createRefundResponse struct {
@@ -349,57 +1200,105 @@ type createRefundResponse struct {
 	Location string
 	// ErrorCodes returns error codes
 	ErrorCodes []errorResponse
+	ResponseInfo
+	// RefundType tells whether the whole paid amount is refunded, it is only set by RefundPayment and FullRefund
+	RefundType RefundType
 }
 
 // CreateRefund A merchant that has received a Swish payment can refund the whole or part of the original transaction
-// amount to the consumer.
+// amount to the consumer. The refund is processed asynchronously in both API versions, the outcome is delivered to the
+// callback URL or can be read with Status on the returned Location. With V1 the refund is created with a POST and Swish
+// assigns the identifier, so InstructionUUID is ignored.
 func (s *Swish) CreateRefund(ctx context.Context, opts CreateRefundOptions) (result createRefundResponse, err error) {
-	body, err := json.Marshal(opts)
+	if s.trimFields {
+		opts.trimSpace()
+	}
+
+	err = validateCallbackIdentifier(opts.CallbackIdentifier)
+	if err != nil {
+		return
+	}
+
+	err = s.checkCallbackURL(opts.CallbackURL)
+	if err != nil {
+		return
+	}
+
+	if s.apiVersion != V1 || opts.InstructionUUID != "" {
+		opts.InstructionUUID, err = formatInstructionUUID(opts.InstructionUUID)
+		if err != nil {
+			return
+		}
+	}
+
+	opts.PayerAlias = s.merchantAlias(ctx, opts.PayerAlias)
+	opts.Amount, err = normalizeAmount(opts.Amount, s.rounding)
 	if err != nil {
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/swish-cpcapi/api/v2/refunds/%s", s.URL, opts.InstructionUUID), bytes.NewBuffer(body))
+	body, err := opts.MarshalRequest()
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	ctx, done := s.track(ctx, opts.InstructionUUID)
+	defer done()
 
-	resp, err := s.client.Do(req)
+	err = s.retry(ctx, func() (err error) {
+		result, err = s.sendRefund(ctx, opts, body)
+		return
+	})
+
+	return
+}
+
+// sendRefund sends the body of a refund and handles the response
+func (s *Swish) sendRefund(ctx context.Context, opts CreateRefundOptions, body []byte) (result createRefundResponse, err error) {
+	instructionUUID := opts.InstructionUUID
+	req, endpoint, err := s.createRequest(ctx, createRefundV1, createRefundV2, instructionUUID, body)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req)
 	if err != nil {
 		return
 	}
 
 	defer resp.Body.Close()
 
+	result.ResponseInfo = s.responseInfo(resp)
+
 	if resp.StatusCode == http.StatusUnprocessableEntity {
-		err = json.NewDecoder(resp.Body).Decode(&result.ErrorCodes)
+		result.ErrorCodes, err = decodeErrors(resp.Body)
 		if err != nil {
 			return
 		}
 
-		var errs string
-		for _, errCode := range result.ErrorCodes {
-			if len(errs) > 0 {
-				errs += " | "
-			}
-			errs += fmt.Sprintf("[%s] %s", errCode.ErrorCode, errCode.ErrorMessage)
-		}
+		return result, s.refundError(result.ErrorCodes, opts)
+	}
 
-		return result, errors.New(errs)
+	if resp.StatusCode == http.StatusConflict {
+		result.ErrorCodes, _ = decodeErrors(resp.Body)
+		return result, &Error{StatusCode: resp.StatusCode, Codes: result.ErrorCodes, message: fmt.Sprintf("%s: %s", ErrDuplicateInstruction, instructionUUID), err: ErrDuplicateInstruction}
 	}
 
 	if resp.StatusCode == http.StatusForbidden {
-		result.ErrorCodes = append(result.ErrorCodes, errorResponse{
-			ErrorCode:             "PA01",
-			ErrorMessage:          "The payeeAlias in the payment request object is not the same as merchant’s Swish number",
-			AdditionalInformation: "",
-		})
-		return result, errors.New("[PA01] The payeeAlias in the payment request object is not the same as merchant’s Swish number")
+		result.ErrorCodes = forbiddenErrors(resp.Body)
+		return result, s.codesError(resp.StatusCode, result.ErrorCodes, nil)
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return result, httpError(resp)
+	}
+
+	if !endpoint.ok(resp.StatusCode) {
+		return result, httpError(resp)
 	}
 
 	result.Location = resp.Header.Get("Location")
+	s.saveLocation(instructionUUID, result.Location)
 
 	return
 }
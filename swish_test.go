@@ -1,14 +1,86 @@
 package swish_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	swish "github.com/Kansuler/payment-swish"
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pkcs12"
 	"io/ioutil"
+	"log"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// testCA returns the Swish CA together with the certificate of server, which every httptest server shares, so that
+// the client verifies the test servers
+func testCA(t *testing.T, server *httptest.Server) string {
+	ca, err := base64.StdEncoding.DecodeString(swish.Certificate)
+	if err != nil {
+		t.Fatalf("could not decode CA: %s", err.Error())
+	}
+
+	ca = append(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})...)
+	return base64.StdEncoding.EncodeToString(ca)
+}
+
+// newTestSwish creates a client against the test environment that talks with a local TLS server running handler
+func newTestSwish(t *testing.T, opts swish.Options, handler http.HandlerFunc) (*swish.Swish, *httptest.Server) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	opts.Passphrase = "swish"
+	opts.SSLCertificate = cert
+	opts.Test = true
+	if opts.CA == "" {
+		opts.CA = testCA(t, server)
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 5
+	}
+
+	s, err := swish.New(opts)
+	if err != nil {
+		t.Fatalf("could not create swish instance: %s", err.Error())
+	}
+
+	s.URL = server.URL
+	return s, server
+}
+
 func TestNew(t *testing.T) {
 	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
 	if err != nil {
@@ -38,6 +110,62 @@ func TestNew(t *testing.T) {
 	assert.Nil(t, s)
 }
 
+func TestValidatePassphrase(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	assert.NoError(t, swish.ValidatePassphrase(cert, "swish"))
+
+	err = swish.ValidatePassphrase(cert, "hsiws")
+	assert.True(t, errors.Is(err, swish.ErrIncorrectPassphrase))
+	assert.False(t, errors.Is(err, swish.ErrInvalidCertificate))
+
+	err = swish.ValidatePassphrase([]byte("not a certificate"), "swish")
+	assert.True(t, errors.Is(err, swish.ErrInvalidCertificate))
+	assert.False(t, errors.Is(err, swish.ErrIncorrectPassphrase))
+}
+
+func TestNew_AESCertificate(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304_AES.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	s, err := swish.New(swish.Options{
+		Passphrase:     "swish",
+		CA:             swish.Certificate,
+		SSLCertificate: cert,
+		Test:           true,
+	})
+	assert.NoError(t, err)
+
+	number, err := s.CertificateMerchantNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", number)
+
+	assert.True(t, errors.Is(swish.ValidatePassphrase(cert, "hsiws"), swish.ErrIncorrectPassphrase))
+
+	cert, err = ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304_Camellia.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	err = swish.ValidatePassphrase(cert, "swish")
+	assert.True(t, errors.Is(err, swish.ErrInvalidCertificate))
+	assert.Contains(t, err.Error(), "openssl pkcs12 -export -legacy")
+}
+
+func TestEnvironments(t *testing.T) {
+	environments := swish.Environments()
+
+	assert.Len(t, environments, 2)
+	assert.Equal(t, "https://mss.cpc.getswish.net", environments["test"].API)
+	assert.Equal(t, "https://cpc.getswish.net", environments["production"].API)
+	assert.Equal(t, "https://mpc.getswish.net/qrg-swish", environments["production"].QR)
+}
+
 func TestSwish_CreatePaymentRequest(t *testing.T) {
 	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
 	if err != nil {
@@ -196,3 +324,3280 @@ func TestSwish_CreateRefund(t *testing.T) {
 	assert.Empty(t, refund.Location)
 	assert.NotEmpty(t, refund.ErrorCodes)
 }
+
+func TestSwish_CreateRefund_APIVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version swish.APIVersion
+		method  string
+		path    string
+	}{
+		{version: "", method: "PUT", path: "/swish-cpcapi/api/v2/refunds/11A86BE70EA346E4B1C39C874173F088"},
+		{version: swish.V2, method: "PUT", path: "/swish-cpcapi/api/v2/refunds/11A86BE70EA346E4B1C39C874173F088"},
+		{version: swish.V1, method: "POST", path: "/swish-cpcapi/api/v1/refunds"},
+	} {
+		var method, path string
+		s, server := newTestSwish(t, swish.Options{APIVersion: tc.version}, func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+			w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/refunds/11A86BE70EA346E4B1C39C874173F088")
+			w.WriteHeader(http.StatusCreated)
+		})
+
+		refund, err := s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+			InstructionUUID:          "11A86BE70EA346E4B1C39C874173F088",
+			OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+			CallbackURL:              "https://localhost:8080/callback",
+			PayerAlias:               "1234679304",
+			Amount:                   "100.01",
+			Currency:                 "SEK",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.method, method)
+		assert.Equal(t, tc.path, path)
+		assert.Equal(t, server.URL+"/swish-cpcapi/api/v1/refunds/11A86BE70EA346E4B1C39C874173F088", refund.Location)
+	}
+}
+
+func TestSwish_CancelPaymentByUUID(t *testing.T) {
+	var method, path, contentType string
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		if path == "/swish-cpcapi/api/v1/paymentrequests/6D6CD7406ECE4542A80152D909EF9F6B" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`[{"errorCode":"RP07","errorMessage":"Transaction status not valid for cancellation"}]`))
+			return
+		}
+
+		w.Write([]byte(`{"id":"D2EB91F4F3A74088970FA108B58BF8D9","status":"CANCELLED"}`))
+	})
+
+	status, err := s.CancelPaymentByUUID(context.Background(), "d2eb91f4-f3a7-4088-970f-a108b58bf8d9")
+	assert.NoError(t, err)
+	assert.Equal(t, "PATCH", method)
+	assert.Equal(t, "/swish-cpcapi/api/v1/paymentrequests/D2EB91F4F3A74088970FA108B58BF8D9", path)
+	assert.Equal(t, "application/json-patch+json", contentType)
+	assert.Equal(t, "CANCELLED", status.Status)
+
+	status, err = s.CancelPaymentByUUID(context.Background(), "6D6CD7406ECE4542A80152D909EF9F6B")
+	assert.True(t, errors.Is(err, swish.ErrNotCancellable))
+	assert.Equal(t, "RP07", status.ErrorCode)
+
+	_, err = s.CancelPaymentByUUID(context.Background(), "771178D5BF45450882F1B53681D")
+	assert.Error(t, err)
+}
+
+func TestSwish_CreatePaymentRequest_Redirect(t *testing.T) {
+	var requests int
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	})
+
+	response, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "302")
+	assert.Empty(t, response.Location)
+	assert.Equal(t, 1, requests)
+}
+
+func TestSwish_CreatePaymentRequest_ExpiresAt(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+		w.Header().Set("PaymentRequestToken", "c28a4061470f4af48973bd2a4642b4fa")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	created := time.Date(2021, 3, 5, 10, 0, 0, 0, time.UTC)
+	swish.SetNow(s, func() time.Time { return created })
+	response, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "c28a4061470f4af48973bd2a4642b4fa", response.PaymentRequestToken)
+	assert.Equal(t, created, response.TokenCreated)
+	assert.Equal(t, created.Add(swish.DefaultTokenValidity), response.ExpiresAt(0))
+	assert.Equal(t, created.Add(time.Minute), response.ExpiresAt(time.Minute))
+}
+
+func TestSwish_CreatePaymentRequest_ExpiresAtTokenValidity(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{TokenValidity: 5 * time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+		w.Header().Set("PaymentRequestToken", "c28a4061470f4af48973bd2a4642b4fa")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	created := time.Date(2021, 3, 5, 10, 0, 0, 0, time.UTC)
+	now := created
+	swish.SetNow(s, func() time.Time { return now })
+	response, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, created.Add(5*time.Minute), response.ExpiresAt(0))
+
+	now = created.Add(time.Minute)
+	assert.Equal(t, response.ExpiresAt(0).Sub(now), s.TimeUntilExpiry(response.TokenCreated))
+	assert.Equal(t, 4*time.Minute, s.TimeUntilExpiry(response.TokenCreated))
+}
+
+func TestSwish_CreatePaymentRequest_CallbackIdentifier(t *testing.T) {
+	var body map[string]interface{}
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	_, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.NotContains(t, body, "callbackIdentifier")
+
+	request.CallbackIdentifier = "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B", body["callbackIdentifier"])
+
+	body = nil
+	request.CallbackIdentifier = "too short"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.Error(t, err)
+	assert.Nil(t, body)
+}
+
+func TestDecodeCallback(t *testing.T) {
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID"}`))
+	r.Header.Set("callbackIdentifier", "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B")
+
+	callback, err := swish.DecodeCallback(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", callback.InstructionUUID)
+	assert.Equal(t, "PAID", callback.Status)
+	assert.Equal(t, "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B", callback.CallbackIdentifier)
+}
+
+func TestNew_CABundle(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	root, err := ioutil.ReadFile("certificates/Swish_TLS_RootCA.pem")
+	if err != nil {
+		t.Fatalf("could not load root certificate: %s", err.Error())
+	}
+
+	chain, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.pem")
+	if err != nil {
+		t.Fatalf("could not load certificate chain: %s", err.Error())
+	}
+
+	// The last certificate of the chain is the issuing CA
+	var intermediate *pem.Block
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		intermediate = block
+	}
+
+	for _, tc := range []struct {
+		bundle []byte
+		log    string
+	}{
+		{bundle: append(append([]byte{}, root...), pem.EncodeToMemory(intermediate)...), log: "added 2 CA certificates"},
+		{bundle: append(append([]byte{}, root...), root...), log: "added 1 CA certificates"},
+	} {
+		var buf bytes.Buffer
+		s, err := swish.New(swish.Options{
+			Passphrase:     "swish",
+			CA:             base64.StdEncoding.EncodeToString(tc.bundle),
+			SSLCertificate: cert,
+			Test:           true,
+			Timeout:        5,
+			Logger:         log.New(&buf, "", 0),
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, s)
+		assert.Contains(t, buf.String(), tc.log)
+	}
+
+	_, err = swish.New(swish.Options{
+		Passphrase:     "swish",
+		CA:             base64.StdEncoding.EncodeToString([]byte("not a certificate")),
+		SSLCertificate: cert,
+		Test:           true,
+		Timeout:        5,
+	})
+
+	assert.Error(t, err)
+}
+
+func TestSwish_AwaitStatusChange(t *testing.T) {
+	var polls int
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+			return
+		}
+
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID"}`))
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	status, err := s.AwaitStatusChange(context.Background(), location, "CREATED", time.Millisecond, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, 3, polls)
+
+	status, err = s.AwaitStatusChange(context.Background(), location, "PAID", time.Millisecond, 20*time.Millisecond)
+	assert.True(t, errors.Is(err, swish.ErrStatusUnchanged))
+	assert.Equal(t, "PAID", status.Status)
+
+	polls = 0
+	for _, interval := range []time.Duration{0, -time.Millisecond} {
+		_, err = s.AwaitStatusChange(context.Background(), location, "PAID", interval, time.Second)
+		assert.True(t, errors.Is(err, swish.ErrInvalidInterval))
+	}
+
+	assert.Equal(t, 0, polls)
+}
+
+func TestAppSwitchPayload(t *testing.T) {
+	payload, err := swish.AppSwitchPayload("c28a4061470f4af48973bd2a4642b4fa", "merchant://order?id=1", swish.IOS)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://app.swish.nu/1/p/sw/?callbackurl=merchant%3A%2F%2Forder%3Fid%3D1&token=c28a4061470f4af48973bd2a4642b4fa", payload)
+
+	payload, err = swish.AppSwitchPayload("c28a4061470f4af48973bd2a4642b4fa", "merchant://order?id=1", swish.Android)
+	assert.NoError(t, err)
+	assert.Equal(t, "swish://paymentrequest?callbackurl=merchant%3A%2F%2Forder%3Fid%3D1&token=c28a4061470f4af48973bd2a4642b4fa", payload)
+
+	_, err = swish.AppSwitchPayload("", "merchant://order", swish.IOS)
+	assert.Error(t, err)
+
+	_, err = swish.AppSwitchPayload("c28a4061470f4af48973bd2a4642b4fa", "/order", swish.Android)
+	assert.Error(t, err)
+
+	_, err = swish.AppSwitchPayload("c28a4061470f4af48973bd2a4642b4fa", "merchant://order", swish.Platform(5))
+	assert.Error(t, err)
+}
+
+func TestSwish_CreatePaymentRequest_SingleError(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"errorCode":"RP03","errorMessage":"Callback URL is missing or does not use HTTPS"}`))
+	})
+
+	response, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+
+	assert.EqualError(t, err, "[RP03] Callback URL is missing or does not use HTTPS")
+	assert.Len(t, response.ErrorCodes, 1)
+	assert.Equal(t, "RP03", response.ErrorCodes[0].ErrorCode)
+}
+
+func TestSwish_Preflight(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`[{"errorCode":"RP04","errorMessage":"No payment request found related to a token"}]`))
+	})
+
+	// The bundled test certificate is valid between 2020-05-13 and 2022-05-13
+	swish.SetNow(s, func() time.Time { return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) })
+	assert.NoError(t, s.Preflight(context.Background()))
+
+	swish.SetNow(s, time.Now)
+	server.Close()
+
+	err := s.Preflight(context.Background())
+	var preflightErr *swish.PreflightError
+	assert.True(t, errors.As(err, &preflightErr))
+	assert.Len(t, preflightErr.Errors, 2)
+	assert.Contains(t, err.Error(), "certificate expired")
+	assert.Contains(t, err.Error(), "ping failed")
+}
+
+func TestSwish_Preflight_Environment(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	swish.SetNow(s, func() time.Time { return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) })
+	swish.SetTest(s, false)
+	err := s.Preflight(context.Background())
+	var preflightErr *swish.PreflightError
+	assert.True(t, errors.As(err, &preflightErr))
+	assert.Len(t, preflightErr.Errors, 1)
+	assert.Contains(t, err.Error(), "production environment is selected")
+}
+
+func TestSwish_Preflight_Unhealthy(t *testing.T) {
+	var status int
+	var body string
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+
+	swish.SetNow(s, func() time.Time { return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) })
+
+	status, body = http.StatusServiceUnavailable, "Swish is down for maintenance"
+	err := s.Preflight(context.Background())
+	var preflightErr *swish.PreflightError
+	assert.True(t, errors.As(err, &preflightErr))
+	assert.Len(t, preflightErr.Errors, 1)
+	assert.True(t, errors.Is(preflightErr.Errors[0], swish.ErrMaintenance))
+
+	status, body = http.StatusInternalServerError, ""
+	err = s.Preflight(context.Background())
+	assert.True(t, errors.As(err, &preflightErr))
+	var httpErr *swish.HTTPError
+	assert.True(t, errors.As(preflightErr.Errors[0], &httpErr))
+	assert.Equal(t, http.StatusInternalServerError, httpErr.StatusCode)
+
+	status = http.StatusBadRequest
+	assert.Error(t, s.Preflight(context.Background()))
+
+	status = http.StatusForbidden
+	assert.Contains(t, s.Preflight(context.Background()).Error(), "certificate rejected")
+}
+
+func TestNew_Proxy(t *testing.T) {
+	var proxied []string
+	s, _ := newTestSwish(t, swish.Options{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			proxied = append(proxied, r.URL.Path)
+			return nil, nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+	})
+
+	_, err := s.Status(context.Background(), s.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"}, proxied)
+}
+
+func TestSwish_Status_DateLayouts(t *testing.T) {
+	for _, tc := range []struct {
+		date     string
+		expected time.Time
+	}{
+		{date: "2020-05-13T07:43:13.123Z", expected: time.Date(2020, 5, 13, 7, 43, 13, 123000000, time.UTC)},
+		{date: "2020-05-13T07:43:13Z", expected: time.Date(2020, 5, 13, 7, 43, 13, 0, time.UTC)},
+		{date: "2020-05-13T09:43:13.123+02:00", expected: time.Date(2020, 5, 13, 7, 43, 13, 123000000, time.UTC)},
+		{date: "2020-05-13T09:43:13+0200", expected: time.Date(2020, 5, 13, 7, 43, 13, 0, time.UTC)},
+		{date: "2020-05-13T07:43:13.123", expected: time.Date(2020, 5, 13, 7, 43, 13, 123000000, time.UTC)},
+		{date: "2020-05-13T07:43:13", expected: time.Date(2020, 5, 13, 7, 43, 13, 0, time.UTC)},
+	} {
+		s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID","dateCreated":%q,"datePaid":%q}`, tc.date, tc.date)
+		})
+
+		status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+		assert.NoError(t, err, tc.date)
+		assert.True(t, tc.expected.Equal(status.DateCreated), tc.date)
+		assert.True(t, tc.expected.Equal(status.DatePaid), tc.date)
+		assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", status.InstructionUUID)
+	}
+
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED","dateCreated":"13/05/2020"}`))
+	})
+
+	_, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+}
+
+func TestSwish_Latency(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+	})
+	swish.SetLatency(s, 50*time.Millisecond)
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := s.Status(ctx, location)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	start := time.Now()
+	_, err = s.Status(context.Background(), location)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestSwish_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	s, server := newTestSwish(t, swish.Options{Logger: log.New(&buf, "", 0)}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "a1b2c3")
+		if r.Method == "GET" {
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	response, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3", response.RequestID)
+	assert.Contains(t, buf.String(), "request id a1b2c3")
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3", status.RequestID)
+}
+
+func TestSwish_FullRefund(t *testing.T) {
+	var refund map[string]interface{}
+	var paymentStatus string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprintf(w, `{"id":"11A86BE70EA346E4B1C39C874173F088","paymentReference":"6D6CD7406ECE4542A80152D909EF9F6B","payeeAlias":"1234679304","amount":100.10,"currency":"SEK","status":%q}`, paymentStatus)
+			return
+		}
+
+		refund = nil
+		json.NewDecoder(r.Body).Decode(&refund)
+		w.Header().Set("Location", "https://"+r.Host+r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+
+	paymentStatus = "PAID"
+	response, err := s.FullRefund(context.Background(), location, "https://localhost:8080/callback")
+	assert.NoError(t, err)
+	assert.Contains(t, response.Location, "/swish-cpcapi/api/v2/refunds/")
+	assert.Equal(t, swish.RefundFull, response.RefundType)
+	assert.Equal(t, "6D6CD7406ECE4542A80152D909EF9F6B", refund["originalPaymentReference"])
+	assert.Equal(t, "1234679304", refund["payerAlias"])
+	assert.Equal(t, "100.10", refund["amount"])
+	assert.Equal(t, "SEK", refund["currency"])
+
+	refund = nil
+	paymentStatus = "CREATED"
+	_, err = s.FullRefund(context.Background(), location, "https://localhost:8080/callback")
+	assert.True(t, errors.Is(err, swish.ErrNotPaid))
+	assert.Nil(t, refund)
+}
+
+func TestNew_DisableKeepAlives(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+	assert.False(t, swish.Transport(s).DisableKeepAlives)
+
+	s, _ = newTestSwish(t, swish.Options{DisableKeepAlives: true}, nil)
+	assert.True(t, swish.Transport(s).DisableKeepAlives)
+}
+
+func TestErrorCodeCatalog(t *testing.T) {
+	catalog := swish.ErrorCodeCatalog()
+
+	assert.NotEmpty(t, catalog)
+	assert.Equal(t, "The payeeAlias in the payment request object is not the same as merchant’s Swish number", catalog[swish.ErrorCodePA01])
+
+	delete(catalog, swish.ErrorCodePA01)
+	assert.Contains(t, swish.ErrorCodeCatalog(), swish.ErrorCodePA01)
+}
+
+func TestSwish_CreatePaymentRequest_WithInstructionUUID(t *testing.T) {
+	var path string
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	response, err := s.CreatePaymentRequest(context.Background(), request, swish.WithInstructionUUID("d2eb91f4-f3a7-4088-970f-a108b58bf8d9"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/swish-cpcapi/api/v2/paymentrequests/D2EB91F4F3A74088970FA108B58BF8D9", path)
+	assert.Equal(t, "D2EB91F4F3A74088970FA108B58BF8D9", response.InstructionUUID)
+
+	response, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088", path)
+
+	request.InstructionUUID = ""
+	response, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^[0-9A-F]{32}$", response.InstructionUUID)
+	assert.Equal(t, "/swish-cpcapi/api/v2/paymentrequests/"+response.InstructionUUID, path)
+
+	_, err = s.CreatePaymentRequest(context.Background(), request, swish.WithInstructionUUID("not-an-id"))
+	assert.Error(t, err)
+
+	request.InstructionUUID = "d2eb91f4-f3a7-4088-970f-a108b58bf8d9"
+	response, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "/swish-cpcapi/api/v2/paymentrequests/D2EB91F4F3A74088970FA108B58BF8D9", path)
+	assert.Equal(t, "D2EB91F4F3A74088970FA108B58BF8D9", response.InstructionUUID)
+
+	request.InstructionUUID = "not-an-id"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.Error(t, err)
+
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "d2eb91f4-f3a7-4088-970f-a108b58bf8d9",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		PayerAlias:               "1234679304",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/swish-cpcapi/api/v2/refunds/D2EB91F4F3A74088970FA108B58BF8D9", path)
+
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "not-an-id",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		PayerAlias:               "1234679304",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.Error(t, err)
+
+	s, _ = newTestSwish(t, swish.Options{APIVersion: swish.V1}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request.InstructionUUID = ""
+	response, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "", response.InstructionUUID)
+}
+
+func TestOptionsSchema(t *testing.T) {
+	var schema struct {
+		Definitions map[string]struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+			Required   []string                          `json:"required"`
+		} `json:"definitions"`
+	}
+
+	assert.NoError(t, json.Unmarshal(swish.OptionsSchema(), &schema))
+
+	payment := schema.Definitions["CreatePaymentRequestOptions"]
+	assert.Contains(t, payment.Required, "amount")
+	assert.NotContains(t, payment.Required, "message")
+	assert.NotContains(t, payment.Required, "payeeAlias")
+	assert.NotEmpty(t, payment.Properties["amount"]["pattern"])
+	assert.Regexp(t, payment.Properties["amount"]["pattern"], "100.01")
+	assert.Regexp(t, payment.Properties["message"]["pattern"], "Tack för ditt köp!")
+	assert.Regexp(t, payment.Properties["message"]["pattern"], "Order 123-456")
+	assert.NotRegexp(t, payment.Properties["message"]["pattern"], "Café")
+	assert.NotRegexp(t, payment.Properties["message"]["pattern"], "Order 12/3")
+	assert.NotContains(t, payment.Properties, "InstructionUUID")
+
+	refund := schema.Definitions["CreateRefundOptions"]
+	assert.Contains(t, refund.Required, "originalPaymentReference")
+	assert.NotContains(t, refund.Required, "payerPaymentReference")
+	assert.NotContains(t, refund.Required, "message")
+	assert.Equal(t, swish.OptionsSchema(), swish.OptionsSchema())
+}
+
+func TestSwish_CreatePaymentRequestAsync(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		<-r.Context().Done()
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	results, errs, cancel := s.CreatePaymentRequestAsync(context.Background(), request)
+	defer cancel()
+
+	select {
+	case result := <-results:
+		assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", result.InstructionUUID)
+	case err := <-errs:
+		t.Fatalf("unexpected error: %s", err)
+	case <-time.After(time.Second):
+		t.Fatal("request did not finish")
+	}
+
+	request.InstructionUUID = "D2EB91F4F3A74088970FA108B58BF8D9"
+	results, errs, cancel = s.CreatePaymentRequestAsync(context.Background(), request)
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("request was not cancelled")
+	}
+
+	assert.Empty(t, results)
+}
+
+func TestSwish_CertificateMerchantNumber(t *testing.T) {
+	var body map[string]interface{}
+	var buf bytes.Buffer
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	s, _ := newTestSwish(t, swish.Options{Logger: log.New(&buf, "", 0), AliasFromCertificate: true}, handler)
+
+	number, err := s.CertificateMerchantNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", number)
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}
+
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", body["payeeAlias"])
+	assert.NotContains(t, buf.String(), "differs")
+
+	request.PayeeAlias = "1231181189"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "1231181189", body["payeeAlias"])
+	assert.Contains(t, buf.String(), "differs from the Swish number 1234679304")
+
+	// The alias is sent as given unless AliasFromCertificate is set
+	s, _ = newTestSwish(t, swish.Options{}, handler)
+	request.PayeeAlias = ""
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "", body["payeeAlias"])
+
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "D2EB91F4F3A74088970FA108B58BF8D9",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "", body["payerAlias"])
+}
+
+func TestSwish_RegisteredNumbers(t *testing.T) {
+	var requests int
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+
+	numbers, err := s.RegisteredNumbers(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1234679304"}, numbers)
+	assert.Equal(t, 0, requests)
+}
+
+func TestSwish_CreatePaymentRequest_Forbidden(t *testing.T) {
+	var body string
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(body))
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	response, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.EqualError(t, err, "[PA01] The payeeAlias in the payment request object is not the same as merchant’s Swish number")
+	assert.Equal(t, "PA01", response.ErrorCodes[0].ErrorCode)
+
+	body = `[{"errorCode":"ACMT01","errorMessage":"Counterpart is not activated"}]`
+	response, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.EqualError(t, err, "[ACMT01] Counterpart is not activated")
+	assert.Equal(t, "ACMT01", response.ErrorCodes[0].ErrorCode)
+}
+
+func TestNew_StrictEnvironmentCheck(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	s, err := swish.New(swish.Options{
+		Passphrase:             "swish",
+		CA:                     swish.Certificate,
+		SSLCertificate:         cert,
+		Test:                   true,
+		StrictEnvironmentCheck: true,
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	s, err = swish.New(swish.Options{
+		Passphrase:             "swish",
+		CA:                     swish.Certificate,
+		SSLCertificate:         cert,
+		Test:                   false,
+		StrictEnvironmentCheck: true,
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "production environment")
+	assert.Nil(t, s)
+
+	s, err = swish.New(swish.Options{
+		Passphrase:     "swish",
+		CA:             swish.Certificate,
+		SSLCertificate: cert,
+		Test:           false,
+	})
+
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+}
+
+func TestNewFromSnapshot(t *testing.T) {
+	source, _ := newTestSwish(t, swish.Options{}, nil)
+
+	s, err := swish.NewFromSnapshot(source.Snapshot(), swish.Options{Test: true, Timeout: 5})
+	assert.NoError(t, err)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+	}))
+	defer server.Close()
+
+	s.URL = server.URL
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATED", status.Status)
+
+	number, err := s.CertificateMerchantNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", number)
+
+	_, err = swish.NewFromSnapshot(swish.Snapshot{}, swish.Options{Test: true})
+	assert.Error(t, err)
+}
+
+func TestSwish_CreatePaymentRequest_AllowedPayeeAliases(t *testing.T) {
+	var requests int
+	s, _ := newTestSwish(t, swish.Options{AllowedPayeeAliases: []string{"1234679304", "1231181189"}}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayeeAlias:  "1231181189",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}
+
+	_, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	request.PayeeAlias = "1239999999"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.True(t, errors.Is(err, swish.ErrPayeeAliasNotAllowed))
+	assert.Equal(t, 1, requests)
+}
+
+func TestDiffPayment(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","payeeAlias":"1234679304","amount":100.10,"currency":"SEK","message":"Order 1","payeePaymentReference":"0123456789","status":"PAID"}`))
+	})
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+
+	sent := swish.CreatePaymentRequestOptions{
+		PayeeAlias:            "1234679304",
+		Amount:                "100.1",
+		Currency:              "SEK",
+		Message:               "Order 1",
+		PayeePaymentReference: "0123456789",
+	}
+
+	assert.Empty(t, swish.DiffPayment(sent, status))
+
+	sent.Message = "Order 1 of 2"
+	assert.Equal(t, []swish.FieldDiff{{Field: "message", Sent: "Order 1 of 2", Got: "Order 1"}}, swish.DiffPayment(sent, status))
+
+	sent.Message = "Order 1"
+	sent.Amount = "100.11"
+	assert.Equal(t, []swish.FieldDiff{{Field: "amount", Sent: "100.11", Got: "100.10"}}, swish.DiffPayment(sent, status))
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	blocks, err := pkcs12.ToPEM(cert, "swish")
+	if err != nil {
+		t.Fatalf("could not decode test certificate: %s", err.Error())
+	}
+
+	var certPEM, keyPEM []byte
+	for _, block := range blocks {
+		if block.Type == "CERTIFICATE" {
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+			continue
+		}
+
+		keyPEM = append(keyPEM, pem.EncodeToMemory(block)...)
+	}
+
+	p12, err := swish.EncodePKCS12(certPEM, keyPEM, "secret")
+	assert.NoError(t, err)
+
+	s, err := swish.New(swish.Options{
+		Passphrase:     "secret",
+		CA:             swish.Certificate,
+		SSLCertificate: p12,
+		Test:           true,
+		Timeout:        5,
+	})
+
+	assert.NoError(t, err)
+	number, err := s.CertificateMerchantNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", number)
+
+	_, err = swish.EncodePKCS12(certPEM, nil, "secret")
+	assert.Error(t, err)
+}
+
+func TestSwish_CreatePaymentRequest_AmountRounding(t *testing.T) {
+	for _, tc := range []struct {
+		rounding swish.AmountRounding
+		amount   string
+		expected string
+	}{
+		{rounding: swish.RoundNone, amount: "100.005"},
+		{rounding: swish.RoundNone, amount: "100.01", expected: "100.01"},
+		{rounding: swish.RoundHalfUp, amount: "100.005", expected: "100.01"},
+		{rounding: swish.RoundHalfUp, amount: "100.004", expected: "100.00"},
+		{rounding: swish.RoundHalfUp, amount: "99.995", expected: "100.00"},
+		{rounding: swish.RoundDown, amount: "100.005", expected: "100.00"},
+		{rounding: swish.RoundDown, amount: "100.1", expected: "100.1"},
+	} {
+		var body map[string]interface{}
+		s, _ := newTestSwish(t, swish.Options{AmountRounding: tc.rounding}, func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+			w.WriteHeader(http.StatusCreated)
+		})
+
+		_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+			CallbackURL: "https://localhost:8080/callback",
+			PayeeAlias:  "1234679304",
+			Amount:      tc.amount,
+			Currency:    "SEK",
+		})
+
+		if tc.expected == "" {
+			assert.Error(t, err, tc.amount)
+			assert.Nil(t, body, tc.amount)
+			continue
+		}
+
+		assert.NoError(t, err, tc.amount)
+		assert.Equal(t, tc.expected, body["amount"], tc.amount)
+	}
+}
+
+func TestSwish_CancelBatch(t *testing.T) {
+	var mu sync.Mutex
+	cancelled := map[string]bool{}
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		status := "CREATED"
+		if id == "D2EB91F4F3A74088970FA108B58BF8D9" {
+			status = "PAID"
+		}
+
+		if r.Method == "PATCH" {
+			mu.Lock()
+			cancelled[id] = true
+			mu.Unlock()
+			status = "CANCELLED"
+		}
+
+		fmt.Fprintf(w, `{"id":%q,"status":%q}`, id, status)
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/"
+	results := s.CancelBatch(context.Background(), []string{location + "11A86BE70EA346E4B1C39C874173F088", location + "D2EB91F4F3A74088970FA108B58BF8D9", location + "6D6CD7406ECE4542A80152D909EF9F6B"}, 2)
+
+	assert.Empty(t, results.Failures())
+	assert.Equal(t, "CANCELLED", results[0].Status.Status)
+	assert.Equal(t, "PAID", results[1].Status.Status)
+	assert.Equal(t, "CANCELLED", results[2].Status.Status)
+	assert.Equal(t, map[string]bool{"11A86BE70EA346E4B1C39C874173F088": true, "6D6CD7406ECE4542A80152D909EF9F6B": true}, cancelled)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = s.CancelBatch(ctx, []string{location + "11A86BE70EA346E4B1C39C874173F088"}, 1)
+	assert.True(t, errors.Is(results[0].Err, context.Canceled))
+}
+
+func TestSwish_CreateRefundBatch(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		if id == "D2EB91F4F3A74088970FA108B58BF8D9" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`[{"errorCode":"RF07","errorMessage":"Transaction declined"}]`))
+			return
+		}
+
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/refunds/"+id)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	refund := func(id string) swish.CreateRefundOptions {
+		return swish.CreateRefundOptions{
+			InstructionUUID:          id,
+			OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+			CallbackURL:              "https://localhost:8080/callback",
+			PayerAlias:               "1234679304",
+			Amount:                   "100.01",
+			Currency:                 "SEK",
+		}
+	}
+
+	results := s.CreateRefundBatch(context.Background(), []swish.CreateRefundOptions{
+		refund("11a86be7-0ea3-46e4-b1c3-9c874173f088"),
+		refund("D2EB91F4F3A74088970FA108B58BF8D9"),
+		refund("not-an-id"),
+		refund("E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F"),
+	}, 2)
+
+	assert.Len(t, results, 4)
+	successes := results.Successes()
+	assert.Len(t, successes, 2)
+	assert.Equal(t, 0, successes[0].Index)
+	assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", successes[0].Status.InstructionUUID)
+	assert.Equal(t, s.URL+"/swish-cpcapi/api/v1/refunds/11A86BE70EA346E4B1C39C874173F088", successes[0].Location)
+	assert.Equal(t, 3, successes[1].Index)
+	assert.Equal(t, "E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F", successes[1].Status.InstructionUUID)
+
+	failures := results.Failures()
+	assert.Len(t, failures, 2)
+	assert.Equal(t, 1, failures[0].Index)
+	assert.Contains(t, failures[0].Err.Error(), "RF07")
+	assert.Empty(t, failures[0].Location)
+	assert.Equal(t, 2, failures[1].Index)
+	assert.Contains(t, failures[1].Err.Error(), "instruction uuid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = s.CreateRefundBatch(ctx, []swish.CreateRefundOptions{refund("11A86BE70EA346E4B1C39C874173F088")}, 1)
+	assert.True(t, errors.Is(results[0].Err, context.Canceled))
+}
+
+func TestSwish_GeneratePayeeQR(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	var body map[string]interface{}
+	var requestPath string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		requestPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+
+	s.QRURL = server.URL + "/qrg-swish"
+	image, err := s.GeneratePayeeQR(context.Background(), swish.PayeeQROptions{
+		Payee:           "1234679304",
+		Amount:          "100.01",
+		AmountEditable:  true,
+		Message:         "Invoice 1",
+		MessageEditable: false,
+		Size:            300,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, png, image)
+	assert.Equal(t, "/qrg-swish/api/v1/prefilled", requestPath)
+	assert.Equal(t, "png", body["format"])
+	assert.Equal(t, map[string]interface{}{"value": "1234679304", "editable": false}, body["payee"])
+	assert.Equal(t, map[string]interface{}{"value": 100.01, "editable": true}, body["amount"])
+	assert.Equal(t, map[string]interface{}{"value": "Invoice 1", "editable": false}, body["message"])
+
+	_, err = s.GeneratePayeeQR(context.Background(), swish.PayeeQROptions{Payee: "1234679304", Amount: "ten"})
+	assert.Error(t, err)
+
+	_, err = s.GeneratePayeeQR(context.Background(), swish.PayeeQROptions{Payee: "swish"})
+	assert.Error(t, err)
+}
+
+func TestSwish_CreatePaymentRequest_CallbackURLCheck(t *testing.T) {
+	source, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayeeAlias:  "1234679304",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}
+
+	// The test environment is never checked
+	_, err := source.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	for _, tc := range []struct {
+		check       swish.CallbackURLCheck
+		callbackURL string
+		err         bool
+		warning     bool
+	}{
+		{check: swish.CallbackURLWarn, callbackURL: "https://localhost:8080/callback", warning: true},
+		{check: swish.CallbackURLError, callbackURL: "https://192.168.1.10/callback", err: true},
+		{check: swish.CallbackURLError, callbackURL: "https://example.com/callback"},
+		{check: swish.CallbackURLOff, callbackURL: "https://127.0.0.1/callback"},
+	} {
+		buf.Reset()
+		s, err := swish.NewFromSnapshot(source.Snapshot(), swish.Options{CallbackURLCheck: tc.check, Logger: log.New(&buf, "", 0)})
+		assert.NoError(t, err)
+
+		s.URL = server.URL
+		request.CallbackURL = tc.callbackURL
+		_, err = s.CreatePaymentRequest(context.Background(), request)
+		assert.Equal(t, tc.err, err != nil, tc.callbackURL)
+		assert.Equal(t, tc.warning, strings.Contains(buf.String(), "not reachable"), tc.callbackURL)
+	}
+}
+
+func TestCreatePaymentRequestOptions_MarshalRequest(t *testing.T) {
+	var sent []byte
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		sent, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	payment := swish.CreatePaymentRequestOptions{
+		InstructionUUID:       "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:           "https://localhost:8080/callback",
+		PayeeAlias:            "1234679304",
+		Amount:                "100.01",
+		Currency:              "SEK",
+		PayeePaymentReference: "0123456789",
+		Message:               "Order 1",
+	}
+
+	body, err := payment.MarshalRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"callbackUrl":"https://localhost:8080/callback","payeeAlias":"1234679304","amount":"100.01","currency":"SEK","payeePaymentReference":"0123456789","message":"Order 1"}`, string(body))
+
+	_, err = s.CreatePaymentRequest(context.Background(), payment)
+	assert.NoError(t, err)
+	assert.Equal(t, body, sent)
+
+	refund := swish.CreateRefundOptions{
+		InstructionUUID:          "D2EB91F4F3A74088970FA108B58BF8D9",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		PayerAlias:               "1234679304",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	}
+
+	body, err = refund.MarshalRequest()
+	assert.NoError(t, err)
+
+	_, err = s.CreateRefund(context.Background(), refund)
+	assert.NoError(t, err)
+	assert.Equal(t, body, sent)
+}
+
+func TestSwish_Status_Gzip(t *testing.T) {
+	var acceptEncoding string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID","amount":100.01}`))
+		gz.Close()
+	})
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "gzip", acceptEncoding)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, 100.01, status.Amount)
+
+	// A created payment request has no body, also when it is marked as gzip
+	for _, chunked := range []bool{false, true} {
+		s, server = newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+path.Base(r.URL.Path))
+			w.WriteHeader(http.StatusCreated)
+			if chunked {
+				w.(http.Flusher).Flush()
+			}
+		})
+
+		payment, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+			InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+			CallbackURL:     "https://localhost:8080/callback",
+			Amount:          "100.01",
+			Currency:        "SEK",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", payment.Location)
+	}
+}
+
+func TestSwish_WaitForFinalStatusUntil(t *testing.T) {
+	var polls int32
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		status := "CREATED"
+		if atomic.AddInt32(&polls, 1) >= 3 && path.Base(r.URL.Path) == "D2EB91F4F3A74088970FA108B58BF8D9" {
+			status = "PAID"
+		}
+
+		fmt.Fprintf(w, `{"id":"11A86BE70EA346E4B1C39C874173F088","status":%q}`, status)
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/"
+	status, err := s.WaitForFinalStatus(context.Background(), location+"D2EB91F4F3A74088970FA108B58BF8D9", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+
+	stop := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(stop) })
+
+	status, err = s.WaitForFinalStatusUntil(context.Background(), location+"11A86BE70EA346E4B1C39C874173F088", time.Millisecond, stop)
+	assert.True(t, errors.Is(err, swish.ErrPollingStopped))
+	assert.Equal(t, "CREATED", status.Status)
+
+	atomic.StoreInt32(&polls, 0)
+	for _, interval := range []time.Duration{0, -time.Millisecond} {
+		_, err = s.WaitForFinalStatus(context.Background(), location+"D2EB91F4F3A74088970FA108B58BF8D9", interval)
+		assert.True(t, errors.Is(err, swish.ErrInvalidInterval))
+
+		_, err = s.CreateAndAwait(context.Background(), swish.CreatePaymentRequestOptions{
+			CallbackURL: "https://localhost:8080/callback",
+			PayeeAlias:  "1234679304",
+			Amount:      "100.01",
+			Currency:    "SEK",
+		}, interval)
+		assert.True(t, errors.Is(err, swish.ErrInvalidInterval))
+	}
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&polls))
+}
+
+func TestSwish_Abort(t *testing.T) {
+	started := make(chan struct{}, 1)
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.WaitForFinalStatus(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", time.Millisecond)
+		errs <- err
+	}()
+
+	<-started
+	s.Abort("6d6cd740-6ece-4542-a801-52d909ef9f6b")
+	assert.Equal(t, 2, swish.InFlight(s))
+
+	start := time.Now()
+	s.Abort("11a86be7-0ea3-46e4-b1c3-9c874173f088")
+	err := <-errs
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, 0, swish.InFlight(s))
+}
+
+func TestSwish_RequestURL(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	payment, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}, swish.WithInstructionUUID("11a86be7-0ea3-46e4-b1c3-9c874173f088"))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088", payment.RequestURL)
+
+	refund, err := s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "6D6CD7406ECE4542A80152D909EF9F6B",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/swish-cpcapi/api/v2/refunds/6D6CD7406ECE4542A80152D909EF9F6B", refund.RequestURL)
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	status, err := s.Status(context.Background(), location)
+	assert.NoError(t, err)
+	assert.Equal(t, location, status.RequestURL)
+}
+
+func TestSwish_Maintenance(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "6D6CD7406ECE4542A80152D909EF9F6B" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`Swish is closed for planned Maintenance`))
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/"
+	_, err := s.Status(context.Background(), location+"11A86BE70EA346E4B1C39C874173F088")
+	assert.True(t, errors.Is(err, swish.ErrMaintenance))
+
+	var maintenanceErr *swish.MaintenanceError
+	assert.True(t, errors.As(err, &maintenanceErr))
+	assert.Equal(t, 2*time.Minute, maintenanceErr.RetryAfter)
+	assert.EqualError(t, err, "swish is under maintenance, retry after 2m0s")
+
+	var httpErr *swish.HTTPError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+
+	_, err = s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	})
+	assert.True(t, errors.Is(err, swish.ErrMaintenance))
+
+	_, err = s.Status(context.Background(), location+"6D6CD7406ECE4542A80152D909EF9F6B")
+	assert.False(t, errors.Is(err, swish.ErrMaintenance))
+	assert.True(t, errors.As(err, &httpErr))
+}
+
+func TestSwish_StatusSSE(t *testing.T) {
+	var polls int32
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&polls, 1) {
+		case 1, 2:
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","payerSSN":"197001019876","status":"CREATED"}`))
+		default:
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","payerSSN":"197001019876","status":"PAID"}`))
+		}
+	})
+
+	recorder := httptest.NewRecorder()
+	err := s.StatusSSE(context.Background(), recorder, server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	assert.True(t, recorder.Flushed)
+
+	events := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n\n"), "\n\n")
+	assert.Len(t, events, 2)
+	assert.Contains(t, events[0], `"status":"CREATED"`)
+	assert.Contains(t, events[1], `"status":"PAID"`)
+	assert.NotContains(t, recorder.Body.String(), "197001019876")
+	for _, event := range events {
+		assert.True(t, strings.HasPrefix(event, "data: {"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.StatusSSE(ctx, httptest.NewRecorder(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/6D6CD7406ECE4542A80152D909EF9F6B", time.Millisecond)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestSwish_Proto(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	payment, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", payment.Proto)
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", status.Proto)
+}
+
+func TestSwish_TLSState(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+	})
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, status.TLSVersion, uint16(tls.VersionTLS12))
+	assert.NotZero(t, status.TLSCipherSuite)
+}
+
+func TestSwish_ReconcileStatuses(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		switch id := path.Base(r.URL.Path); id {
+		case "11A86BE70EA346E4B1C39C874173F088":
+			fmt.Fprintf(w, `{"id":%q,"status":"PAID"}`, id)
+		case "6D6CD7406ECE4542A80152D909EF9F6B":
+			fmt.Fprintf(w, `{"id":%q,"status":"DECLINED"}`, id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`[{"errorCode":"RP04","errorMessage":"No payment request found"}]`))
+		}
+	})
+
+	statuses, errs := s.ReconcileStatuses(context.Background(), []string{
+		"11a86be7-0ea3-46e4-b1c3-9c874173f088",
+		"6D6CD7406ECE4542A80152D909EF9F6B",
+		"D2EB91F4F3A74088970FA108B58BF8D9",
+		"not-a-uuid",
+	}, 2)
+
+	assert.Len(t, statuses, 4)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, "PAID", statuses[0].Status)
+	assert.NoError(t, errs[1])
+	assert.Equal(t, "DECLINED", statuses[1].Status)
+	assert.EqualError(t, errs[2], "[RP04] No payment request found")
+	assert.Error(t, errs[3])
+}
+
+func TestSwish_ErrorShapes(t *testing.T) {
+	var body string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+
+		w.Write([]byte(body))
+	})
+
+	for _, body = range []string{
+		`[{"errorCode":"RP03","errorMessage":"Callback URL is missing or does not use HTTPS"}]`,
+		`{"errorCode":"RP03","errorMessage":"Callback URL is missing or does not use HTTPS"}`,
+		`{"errors":[{"errorCode":"RP03","errorMessage":"Callback URL is missing or does not use HTTPS"}]}`,
+	} {
+		_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+			CallbackURL: "https://localhost:8080/callback",
+			Amount:      "100.01",
+			Currency:    "SEK",
+		})
+
+		var swishErr *swish.Error
+		assert.True(t, errors.As(err, &swishErr), body)
+		assert.Equal(t, []swish.ErrorResponse{{ErrorCode: "RP03", ErrorMessage: "Callback URL is missing or does not use HTTPS"}}, swishErr.Codes, body)
+		assert.EqualError(t, err, "[RP03] Callback URL is missing or does not use HTTPS", body)
+
+		_, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+		assert.EqualError(t, err, "[RP03] Callback URL is missing or does not use HTTPS", body)
+	}
+}
+
+type mapLocationStore map[string]string
+
+func (m mapLocationStore) Save(uuid, location string) {
+	m[uuid] = location
+}
+
+func (m mapLocationStore) Load(uuid string) (string, bool) {
+	location, ok := m[uuid]
+	return location, ok
+}
+
+func TestSwish_StatusByStoredUUID(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		if r.Method == http.MethodGet {
+			fmt.Fprintf(w, `{"id":%q,"status":"PAID"}`, id)
+			return
+		}
+
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+id)
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	store := mapLocationStore{}
+	s, server := newTestSwish(t, swish.Options{LocationStore: store}, handler)
+
+	_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}, swish.WithInstructionUUID("11a86be7-0ea3-46e4-b1c3-9c874173f088"))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", store["11A86BE70EA346E4B1C39C874173F088"])
+
+	status, err := s.StatusByStoredUUID(context.Background(), "11a86be7-0ea3-46e4-b1c3-9c874173f088")
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+
+	_, err = s.StatusByStoredUUID(context.Background(), "6D6CD7406ECE4542A80152D909EF9F6B")
+	assert.True(t, errors.Is(err, swish.ErrLocationNotFound))
+
+	s, _ = newTestSwish(t, swish.Options{}, handler)
+	_, err = s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:     "https://localhost:8080/callback",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+	assert.NoError(t, err)
+
+	_, err = s.StatusByStoredUUID(context.Background(), "6D6CD7406ECE4542A80152D909EF9F6B")
+	assert.True(t, errors.Is(err, swish.ErrLocationNotFound))
+
+	// With V1 Swish assigns the id at the end of the location
+	store = mapLocationStore{}
+	s, server = newTestSwish(t, swish.Options{APIVersion: swish.V1, LocationStore: store}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprintf(w, `{"id":%q,"status":"PAID"}`, path.Base(r.URL.Path))
+			return
+		}
+
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	_, err = s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID: "D2EB91F4F3A74088970FA108B58BF8D9",
+		CallbackURL:     "https://localhost:8080/callback",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	})
+	assert.NoError(t, err)
+
+	status, err = s.StatusByStoredUUID(context.Background(), "D2EB91F4F3A74088970FA108B58BF8D9")
+	assert.NoError(t, err)
+	assert.Equal(t, "E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F", status.InstructionUUID)
+}
+
+func TestSwish_ValidateBatch(t *testing.T) {
+	var requests int
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+
+	valid := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	badAmount := valid
+	badAmount.InstructionUUID = "6D6CD7406ECE4542A80152D909EF9F6B"
+	badAmount.Amount = "100.001"
+
+	badIdentifier := valid
+	badIdentifier.InstructionUUID = "D2EB91F4F3A74088970FA108B58BF8D9"
+	badIdentifier.CallbackIdentifier = "short"
+
+	other := valid
+	other.InstructionUUID = "E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F"
+
+	errs := s.ValidateBatch([]swish.CreatePaymentRequestOptions{valid, badAmount, other, badIdentifier, valid})
+	assert.Len(t, errs, 5)
+	assert.NoError(t, errs[0])
+	assert.EqualError(t, errs[1], `amount "100.001" has more than two decimals`)
+	assert.NoError(t, errs[2])
+	assert.EqualError(t, errs[3], "callback identifier must be between 32 and 36 characters, got 5")
+	assert.True(t, errors.Is(errs[4], swish.ErrDuplicateInstruction))
+	assert.Equal(t, 0, requests)
+
+	dashed := valid
+	dashed.InstructionUUID = "11a86be7-0ea3-46e4-b1c3-9c874173f088"
+	errs = s.ValidateBatch([]swish.CreatePaymentRequestOptions{valid, dashed})
+	assert.NoError(t, errs[0])
+	assert.True(t, errors.Is(errs[1], swish.ErrDuplicateInstruction))
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*swish.CreatePaymentRequestOptions)
+		err    string
+	}{
+		{"generated instruction uuid", func(o *swish.CreatePaymentRequestOptions) { o.InstructionUUID = "" }, ""},
+		{"optional fields", func(o *swish.CreatePaymentRequestOptions) {
+			o.PayerAlias = "46712345678"
+			o.PayeePaymentReference = "0123456789"
+			o.Message = "Order 123-456"
+		}, ""},
+		{"missing callback url", func(o *swish.CreatePaymentRequestOptions) { o.CallbackURL = "" }, "callbackUrl is required"},
+		{"missing payee alias", func(o *swish.CreatePaymentRequestOptions) { o.PayeeAlias = "" }, "payeeAlias is required"},
+		{"missing amount", func(o *swish.CreatePaymentRequestOptions) { o.Amount = "" }, "amount is required"},
+		{"missing currency", func(o *swish.CreatePaymentRequestOptions) { o.Currency = "" }, "currency is required"},
+		{"http callback url", func(o *swish.CreatePaymentRequestOptions) { o.CallbackURL = "http://localhost:8080/callback" }, `callbackUrl "http://localhost:8080/callback" does not match ^https://`},
+		{"short payee alias", func(o *swish.CreatePaymentRequestOptions) { o.PayeeAlias = "123" }, `payeeAlias "123" does not match ^[0-9]{8,15}$`},
+		{"malformed amount", func(o *swish.CreatePaymentRequestOptions) { o.Amount = "100,01" }, `amount "100,01" does not match`},
+		{"unknown currency", func(o *swish.CreatePaymentRequestOptions) { o.Currency = "EUR" }, `currency "EUR" must be one of SEK`},
+		{"malformed payer alias", func(o *swish.CreatePaymentRequestOptions) { o.PayerAlias = "4671234567a" }, `payerAlias "4671234567a" does not match ^[0-9]{8,15}$`},
+		{"malformed reference", func(o *swish.CreatePaymentRequestOptions) { o.PayeePaymentReference = "order #1" }, `payeePaymentReference "order #1" does not match`},
+		{"malformed ssn", func(o *swish.CreatePaymentRequestOptions) { o.PayerSSN = "19800101" }, `payerSSN "19800101" does not match ^[0-9]{12}$`},
+		{"malformed age limit", func(o *swish.CreatePaymentRequestOptions) { o.PayerAgeLimit = "0" }, `payerAgeLimit "0" does not match`},
+		{"invalid message character", func(o *swish.CreatePaymentRequestOptions) { o.Message = "Café" }, `message "Café" does not match`},
+		{"long message", func(o *swish.CreatePaymentRequestOptions) { o.Message = strings.Repeat("a", 51) }, "is longer than 50 characters"},
+		{"malformed instruction uuid", func(o *swish.CreatePaymentRequestOptions) { o.InstructionUUID = "not-an-id" }, `instruction uuid "not-an-id" must be 32 hexadecimal characters`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := valid
+			tc.modify(&opts)
+			errs := s.ValidateBatch([]swish.CreatePaymentRequestOptions{opts})
+			if tc.err == "" {
+				assert.NoError(t, errs[0])
+				return
+			}
+
+			if assert.Error(t, errs[0]) {
+				assert.Contains(t, errs[0].Error(), tc.err)
+			}
+		})
+	}
+
+	assert.Equal(t, 0, requests)
+}
+
+func TestSwish_PaymentForRefund(t *testing.T) {
+	var requests []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch path.Base(r.URL.Path) {
+		case "D2EB91F4F3A74088970FA108B58BF8D9":
+			w.Write([]byte(`{"id":"D2EB91F4F3A74088970FA108B58BF8D9","originalPaymentReference":"6D6CD7406ECE4542A80152D909EF9F6B","payerPaymentReference":"refund 1","amount":50,"currency":"SEK","status":"PAID"}`))
+		case "11A86BE70EA346E4B1C39C874173F088":
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","paymentReference":"6D6CD7406ECE4542A80152D909EF9F6B","amount":100,"currency":"SEK","status":"PAID"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`[{"errorCode":"RP04","errorMessage":"No payment request found"}]`))
+		}
+	}
+
+	store := mapLocationStore{}
+	s, server := newTestSwish(t, swish.Options{LocationStore: store}, handler)
+
+	refund, err := s.RefundStatus(context.Background(), server.URL+"/swish-cpcapi/api/v1/refunds/D2EB91F4F3A74088970FA108B58BF8D9")
+	assert.NoError(t, err)
+	assert.Equal(t, "6D6CD7406ECE4542A80152D909EF9F6B", refund.OriginalPaymentReference)
+	assert.Equal(t, "refund 1", refund.PayerPaymentReference)
+	assert.Equal(t, "PAID", refund.Status)
+
+	// The payment reference from the bank is not a payment request id, so nothing is requested until it is known
+	requests = nil
+	_, err = s.PaymentForRefund(context.Background(), refund)
+	assert.True(t, errors.Is(err, swish.ErrPaymentNotResolvable))
+	assert.Empty(t, requests)
+
+	_, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+
+	payment, err := s.PaymentForRefund(context.Background(), refund)
+	assert.NoError(t, err)
+	assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", payment.InstructionUUID)
+	assert.Equal(t, 100.0, payment.Amount)
+
+	s, server = newTestSwish(t, swish.Options{}, handler)
+	_, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+
+	_, err = s.PaymentForRefund(context.Background(), refund)
+	assert.True(t, errors.Is(err, swish.ErrPaymentNotResolvable))
+}
+
+func TestSwish_RefundPayment(t *testing.T) {
+	var refunds int
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","paymentReference":"6D6CD7406ECE4542A80152D909EF9F6B","payeeAlias":"1234679304","amount":100.01,"currency":"SEK","status":"PAID"}`))
+			return
+		}
+
+		refunds++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	payment, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+
+	refund, err := s.RefundPayment(context.Background(), payment, swish.CreateRefundOptions{
+		InstructionUUID: "D2EB91F4F3A74088970FA108B58BF8D9",
+		CallbackURL:     "https://localhost:8080/callback",
+		Amount:          "50.00",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refunds)
+	assert.Equal(t, swish.RefundPartial, refund.RefundType)
+
+	refund, err = s.RefundPayment(context.Background(), payment, swish.CreateRefundOptions{
+		InstructionUUID: "0D6C8F0A7B1E4B5DA2A1C4F3E7B9D812",
+		CallbackURL:     "https://localhost:8080/callback",
+		Amount:          "100.01",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, refunds)
+	assert.Equal(t, swish.RefundFull, refund.RefundType)
+
+	// An amount above the paid amount is not a partial refund
+	refund, err = s.RefundPayment(context.Background(), payment, swish.CreateRefundOptions{
+		InstructionUUID: "E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F",
+		CallbackURL:     "https://localhost:8080/callback",
+		Amount:          "150.00",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, refunds)
+	assert.Equal(t, swish.RefundUnknown, refund.RefundType)
+
+	// The refund must come from the Swish number that received the payment
+	_, err = s.RefundPayment(context.Background(), payment, swish.CreateRefundOptions{
+		InstructionUUID: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayerAlias:      "46701234567",
+		Amount:          "50.00",
+	})
+	assert.True(t, errors.Is(err, swish.ErrRefundPayerMismatch))
+	assert.Equal(t, 3, refunds)
+}
+
+func TestSwish_LeafCertificate(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+
+	leaf, err := s.LeafCertificate()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234679304", leaf.Subject.CommonName)
+	assert.Equal(t, "Nordea Customer CA1 v2 for Swish", leaf.Issuer.CommonName)
+}
+
+func TestSwish_CreatePaymentRequest_Duplicate(t *testing.T) {
+	var conflict bool
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if conflict {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"RP09","errorMessage":"The given instructionUUID is not available"}]`))
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	_, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.True(t, errors.Is(err, swish.ErrDuplicateInstruction))
+	assert.Contains(t, err.Error(), "RP09")
+
+	conflict = true
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.True(t, errors.Is(err, swish.ErrDuplicateInstruction))
+
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "D2EB91F4F3A74088970FA108B58BF8D9",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.True(t, errors.Is(err, swish.ErrDuplicateInstruction))
+}
+
+func TestMaskMSISDN(t *testing.T) {
+	assert.Equal(t, "•••• 9304", swish.MaskMSISDN("1234679304"))
+	assert.Equal(t, "•••• 5678", swish.MaskMSISDN("+46 70-123 45 678"))
+	assert.Equal(t, "••••", swish.MaskMSISDN("123"))
+	assert.Equal(t, "", swish.MaskMSISDN(""))
+	assert.Equal(t, "", swish.MaskMSISDN("unknown"))
+}
+
+func TestNormalizeMSISDN(t *testing.T) {
+	for _, input := range []string{
+		"070-123 45 67",
+		"0701234567",
+		"+46 70 123 45 67",
+		"+46 (0)70 123 45 67",
+		"0046701234567",
+		"46701234567",
+	} {
+		number, err := swish.NormalizeMSISDN(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, "46701234567", number, input)
+	}
+
+	for _, input := range []string{"", "070-abc", "+46", "1234567890123456"} {
+		_, err := swish.NormalizeMSISDN(input)
+		assert.Error(t, err, input)
+	}
+
+	var body map[string]interface{}
+	s, _ := newTestSwish(t, swish.Options{NormalizePayerAlias: true}, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayerAlias:  "070-123 45 67",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "46701234567", body["payerAlias"])
+}
+
+func TestSwish_TrimFields(t *testing.T) {
+	var body map[string]interface{}
+	s, _ := newTestSwish(t, swish.Options{TrimFields: true}, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL:           "https://localhost:8080/callback",
+		Amount:                "100.01",
+		Currency:              "SEK",
+		PayeePaymentReference: "  order 123\t",
+		Message:               "\nThanks for your order ",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "order 123", body["payeePaymentReference"])
+	assert.Equal(t, "Thanks for your order", body["message"])
+
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "6D6CD7406ECE4542A80152D909EF9F6B",
+		OriginalPaymentReference: " 6D6CD7406ECE4542A80152D909EF9F6B ",
+		CallbackURL:              "https://localhost:8080/callback",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+		PayerPaymentReference:    " refund 1 ",
+		Message:                  " Refund ",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "6D6CD7406ECE4542A80152D909EF9F6B", body["originalPaymentReference"])
+	assert.Equal(t, "refund 1", body["payerPaymentReference"])
+	assert.Equal(t, "Refund", body["message"])
+}
+
+func TestSwish_CreatePaymentRequest_Flow(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["payerAlias"] == "46700000000" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`[{"errorCode":"ACMT03","errorMessage":"Payer not Enrolled"}]`))
+			return
+		}
+
+		if body["payerAlias"] == nil {
+			w.Header().Set("PaymentRequestToken", "c28a4061470f4af48973bd2a4642b4fa")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}
+
+	payment, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, swish.MCommerce, payment.Flow)
+	assert.False(t, payment.AwaitingPayer)
+	assert.NotEmpty(t, payment.PaymentRequestToken)
+
+	request.PayerAlias = "46701234567"
+	payment, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, swish.ECommerce, payment.Flow)
+	assert.True(t, payment.AwaitingPayer)
+	assert.Empty(t, payment.PaymentRequestToken)
+
+	// A rejected payment request is not awaiting the payer
+	request.PayerAlias = "46700000000"
+	payment, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.Error(t, err)
+	assert.Equal(t, swish.FlowUnknown, payment.Flow)
+	assert.False(t, payment.AwaitingPayer)
+}
+
+func TestSwish_CreateAndAwait(t *testing.T) {
+	var polls int32
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		if r.Method == http.MethodPut {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["payerAlias"] == nil {
+				w.Header().Set("PaymentRequestToken", "c28a4061470f4af48973bd2a4642b4fa")
+			}
+
+			w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+id)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		status := "CREATED"
+		if atomic.AddInt32(&polls, 1) >= 3 {
+			status = "PAID"
+		}
+
+		fmt.Fprintf(w, `{"id":%q,"status":%q}`, id, status)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayerAlias:      "46701234567",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	status, err := s.CreateAndAwait(context.Background(), request, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", status.InstructionUUID)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&polls))
+
+	request.InstructionUUID = "6D6CD7406ECE4542A80152D909EF9F6B"
+	request.PayerAlias = ""
+	status, err = s.CreateAndAwait(context.Background(), request, time.Millisecond, swish.WithAppSwitch())
+	assert.True(t, errors.Is(err, swish.ErrAppSwitchRequired))
+	assert.Equal(t, "CREATED", status.Status)
+
+	var appSwitch *swish.AppSwitchError
+	assert.True(t, errors.As(err, &appSwitch))
+	assert.Equal(t, "c28a4061470f4af48973bd2a4642b4fa", appSwitch.Payment.PaymentRequestToken)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&polls))
+
+	// Without WithAppSwitch an m-commerce payment request is polled, e.g. for a QR code
+	request.InstructionUUID = "D2EB91F4F3A74088970FA108B58BF8D9"
+	status, err = s.CreateAndAwait(context.Background(), request, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&polls))
+}
+
+func TestSwish_Health(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{CA: swish.Certificate}, nil)
+	notAfter := time.Date(2022, time.May, 13, 7, 43, 13, 0, time.UTC)
+
+	swish.SetNow(s, func() time.Time { return notAfter.Add(-30*24*time.Hour - time.Hour) })
+	assert.Equal(t, swish.HealthReport{
+		CertificateNotAfter: notAfter,
+		DaysRemaining:       30,
+		CACertificates:      1,
+		Environment:         "test",
+	}, s.Health())
+
+	swish.SetNow(s, func() time.Time { return notAfter.Add(time.Hour) })
+	assert.Equal(t, -1, s.Health().DaysRemaining)
+}
+
+func TestSwish_SignPayout(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err.Error())
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	payload := []byte(`{"payoutInstructionUUID":"E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F","amount":"100.00"}`)
+
+	s, _ := newTestSwish(t, swish.Options{PayoutSigningKey: keyPEM}, nil)
+	signature, err := s.SignPayout(payload)
+	assert.NoError(t, err)
+
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	assert.NoError(t, err)
+	digest512 := sha512.Sum512(payload)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA512, digest512[:], raw))
+
+	s, _ = newTestSwish(t, swish.Options{PayoutSigningKey: keyPEM, PayoutSignatureHash: crypto.SHA256}, nil)
+	signature, err = s.SignPayout(payload)
+	assert.NoError(t, err)
+
+	raw, err = base64.StdEncoding.DecodeString(signature)
+	assert.NoError(t, err)
+	digest256 := sha256.Sum256(payload)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest256[:], raw))
+
+	s, _ = newTestSwish(t, swish.Options{}, nil)
+	_, err = s.SignPayout(payload)
+	assert.True(t, errors.Is(err, swish.ErrNoPayoutSigningKey))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err.Error())
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	assert.NoError(t, err)
+
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	_, err = swish.New(swish.Options{
+		Passphrase:       "swish",
+		CA:               swish.Certificate,
+		SSLCertificate:   cert,
+		Test:             true,
+		PayoutSigningKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+	})
+	assert.EqualError(t, err, "payout signing key must be an RSA key")
+}
+
+func TestVerifyPayoutSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err.Error())
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(0x5A3C), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %s", err.Error())
+	}
+
+	signingCert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	s, _ := newTestSwish(t, swish.Options{PayoutSigningKey: keyPEM}, nil)
+
+	signed := []byte(`{"payoutInstructionUUID":"E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F","amount":"100.00"}`)
+	signature, err := s.SignPayout(signed)
+	assert.NoError(t, err)
+	assert.NoError(t, swish.VerifyPayoutSignature(signed, signature, signingCert))
+
+	tampered := []byte(`{"payoutInstructionUUID":"E8D4F5A5C8B94C4D8F7E1A2B3C4D5E6F","amount":"900.00"}`)
+	assert.Error(t, swish.VerifyPayoutSignature(tampered, signature, signingCert))
+
+	raw, _ := base64.StdEncoding.DecodeString(signature)
+	raw[0] ^= 0xFF
+	assert.Error(t, swish.VerifyPayoutSignature(signed, base64.StdEncoding.EncodeToString(raw), signingCert))
+
+	// Swish only accepts SHA-512 signatures
+	s, _ = newTestSwish(t, swish.Options{PayoutSigningKey: keyPEM, PayoutSignatureHash: crypto.SHA256}, nil)
+	signature, err = s.SignPayout(signed)
+	assert.NoError(t, err)
+	assert.Error(t, swish.VerifyPayoutSignature(signed, signature, signingCert))
+}
+
+func TestStatusResponse_Receipt(t *testing.T) {
+	var status swish.StatusResponse
+	err := json.Unmarshal([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","payeePaymentReference":"0123456789","paymentReference":"6D6CD7406ECE4542A80152D909EF9F6B","payerAlias":"46712345678","payeeAlias":"1234679304","amount":100,"currency":"SEK","message":"Kingston USB Flash Drive 8 GB","status":"PAID","dateCreated":"2020-06-05T10:12:04.472Z","datePaid":"2020-06-05T10:12:08.472Z"}`), &status)
+	assert.NoError(t, err)
+
+	assert.Equal(t, swish.Receipt{
+		InstructionUUID:       "11A86BE70EA346E4B1C39C874173F088",
+		Amount:                "100.00",
+		Currency:              "SEK",
+		Payee:                 "1234679304",
+		Payer:                 "•••• 5678",
+		PaidAt:                time.Date(2020, time.June, 5, 10, 12, 8, 472000000, time.UTC),
+		PaymentReference:      "6D6CD7406ECE4542A80152D909EF9F6B",
+		PayeePaymentReference: "0123456789",
+		Message:               "Kingston USB Flash Drive 8 GB",
+	}, status.Receipt())
+}
+
+func TestStatusResponse_Outcome(t *testing.T) {
+	for status, expected := range map[string][2]bool{
+		"PAID":      {true, true},
+		"DECLINED":  {true, false},
+		"ERROR":     {true, false},
+		"CANCELLED": {true, false},
+		"CREATED":   {false, false},
+	} {
+		final, success := swish.StatusResponse{Status: status}.Outcome()
+		assert.Equal(t, expected, [2]bool{final, success}, status)
+	}
+}
+
+func TestStatus_AllowedTransitions(t *testing.T) {
+	assert.Equal(t, []swish.Status{swish.StatusPaid, swish.StatusDeclined, swish.StatusError, swish.StatusCancelled}, swish.StatusCreated.AllowedTransitions())
+	assert.True(t, swish.StatusCreated.CanTransitionTo(swish.StatusPaid))
+	assert.False(t, swish.StatusCreated.CanTransitionTo(swish.StatusCreated))
+	assert.False(t, swish.StatusPaid.CanTransitionTo(swish.StatusCreated))
+	assert.False(t, swish.StatusPaid.CanTransitionTo(swish.StatusCancelled))
+	assert.Empty(t, swish.StatusPaid.AllowedTransitions())
+	assert.Empty(t, swish.Status("UNKNOWN").AllowedTransitions())
+}
+
+func TestSwish_MessageTemplate(t *testing.T) {
+	var body map[string]interface{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	s, _ := newTestSwish(t, swish.Options{MessageTemplate: "Order {payeePaymentReference}"}, handler)
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL:           "https://localhost:8080/callback",
+		Amount:                "100.01",
+		Currency:              "SEK",
+		PayeePaymentReference: "123",
+	}
+
+	_, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "Order 123", body["message"])
+
+	request.Message = "Tack för ditt köp"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "Tack för ditt köp", body["message"])
+
+	request.Message = ""
+	request.PayeePaymentReference = strings.Repeat("1", 45)
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.EqualError(t, err, fmt.Sprintf("message %q from template is longer than 50 characters", "Order "+request.PayeePaymentReference))
+
+	request.PayeePaymentReference = "123-456"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "Order 123-456", body["message"])
+
+	request.PayeePaymentReference = "12/3"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.EqualError(t, err, `message "Order 12/3" from template contains invalid character '/'`)
+
+	// Only å, ä and ö are allowed besides a-z, as in OptionsSchema
+	s, _ = newTestSwish(t, swish.Options{MessageTemplate: "Café {payeePaymentReference}"}, handler)
+	request.PayeePaymentReference = "123"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.EqualError(t, err, `message "Café 123" from template contains invalid character 'é'`)
+
+	s, _ = newTestSwish(t, swish.Options{MessageTemplate: "Order {payeePaymentReference}", TruncateMessage: true}, handler)
+	request.PayeePaymentReference = strings.Repeat("1", 45)
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, "Order "+strings.Repeat("1", 44), body["message"])
+}
+
+func TestNew_TLSHandshakeTimeout(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+	assert.Equal(t, swish.DefaultTLSHandshakeTimeout, swish.Transport(s).TLSHandshakeTimeout)
+
+	s, _ = newTestSwish(t, swish.Options{TLSHandshakeTimeout: 3 * time.Second}, nil)
+	assert.Equal(t, 3*time.Second, swish.Transport(s).TLSHandshakeTimeout)
+}
+
+func TestSwish_ResponseHeaderTimeout(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{ResponseHeaderTimeout: 100 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	})
+
+	start := time.Now()
+	_, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	var netErr *swish.NetworkError
+	assert.True(t, errors.As(err, &netErr))
+}
+
+func TestNew_TLSPolicy(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+	config := swish.Transport(s).TLSClientConfig
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.NotEmpty(t, config.CipherSuites)
+
+	s, _ = newTestSwish(t, swish.Options{TLSPolicy: swish.TLSCompatible}, nil)
+	config = swish.Transport(s).TLSClientConfig
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Empty(t, config.CipherSuites)
+
+	s, server := newTestSwish(t, swish.Options{TLSPolicy: swish.TLSStrict}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"CREATED"}`))
+	})
+	assert.Equal(t, uint16(tls.VersionTLS13), swish.Transport(s).TLSClientConfig.MinVersion)
+
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), status.TLSVersion)
+
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	if err != nil {
+		t.Fatalf("could not load test certificate: %s", err.Error())
+	}
+
+	_, err = swish.New(swish.Options{Passphrase: "swish", CA: swish.Certificate, SSLCertificate: cert, Test: true, TLSPolicy: 7})
+	assert.EqualError(t, err, "unknown TLS policy 7")
+}
+
+func TestSwish_CreatePaymentRequestIdempotent(t *testing.T) {
+	created := map[string]bool{}
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		switch r.Method {
+		case http.MethodPut:
+			if created[id] {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+
+			created[id] = true
+			w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+id)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			w.Write([]byte(`{"id":"` + id + `","status":"CREATED","payeeAlias":"1234679304","amount":100.01,"currency":"SEK"}`))
+		}
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	first, err := s.CreatePaymentRequestIdempotent(context.Background(), request)
+	assert.NoError(t, err)
+
+	retry, err := s.CreatePaymentRequestIdempotent(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", retry.Location)
+	assert.Equal(t, first.Location, retry.Location)
+	assert.Equal(t, first.InstructionUUID, retry.InstructionUUID)
+
+	request.InstructionUUID = "11a86be7-0ea3-46e4-b1c3-9c874173f088"
+	retry, err = s.CreatePaymentRequestIdempotent(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Location, retry.Location)
+
+	// Another request that reuses the InstructionUUID is not reported as created
+	other := request
+	other.Amount = "200.00"
+	_, err = s.CreatePaymentRequestIdempotent(context.Background(), other)
+	assert.True(t, errors.Is(err, swish.ErrDuplicateInstruction))
+	assert.Contains(t, err.Error(), "amount")
+
+	request.InstructionUUID = ""
+	_, err = s.CreatePaymentRequestIdempotent(context.Background(), request)
+	assert.Error(t, err)
+
+	s, _ = newTestSwish(t, swish.Options{APIVersion: swish.V1}, nil)
+	request.InstructionUUID = "11A86BE70EA346E4B1C39C874173F088"
+	_, err = s.CreatePaymentRequestIdempotent(context.Background(), request)
+	assert.Error(t, err)
+}
+
+func TestNew_Language(t *testing.T) {
+	var language string
+	s, server := newTestSwish(t, swish.Options{Language: "sv"}, func(w http.ResponseWriter, r *http.Request) {
+		language = r.Header.Get("Accept-Language")
+		w.Write([]byte(`{"status":"CREATED"}`))
+	})
+
+	_, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "sv", language)
+	assert.NotNil(t, swish.Transport(s))
+}
+
+func TestCreatePaymentRequestOptions_Fingerprint(t *testing.T) {
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID:       "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:           "https://localhost:8080/callback",
+		PayeeAlias:            "1234679304",
+		Amount:                "100.1",
+		Currency:              "SEK",
+		PayeePaymentReference: "order-1",
+		Message:               "Kingston USB Flash Drive 8 GB",
+	}
+
+	fingerprint := request.Fingerprint()
+	assert.Len(t, fingerprint, 64)
+
+	retry := request
+	retry.InstructionUUID = "D2EB91F4F3A74088970FA108B58BF8D9"
+	retry.CallbackURL = "https://localhost:8080/other"
+	retry.Amount = "100.10"
+	assert.Equal(t, fingerprint, retry.Fingerprint())
+
+	retry.Amount = "100.11"
+	assert.NotEqual(t, fingerprint, retry.Fingerprint())
+}
+
+func TestSwish_StatusRaw(t *testing.T) {
+	body := `{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID","amount":100.01,"currency":"SEK","dateCreated":"2020-06-05T10:12:04.472Z"}`
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		w.Write([]byte(body))
+	})
+
+	raw, status, err := s.StatusRaw(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(raw))
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, "abc", status.RequestID)
+
+	var decoded swish.StatusResponse
+	assert.NoError(t, json.Unmarshal(raw, &decoded))
+	decoded.ResponseInfo = status.ResponseInfo
+	assert.Equal(t, status, decoded)
+}
+
+func TestSwish_PayoutStatus(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "EBB5C73503084E3C9A6EB2C2B1E1E7E4":
+			w.Write([]byte(`{"paymentReference":"1E2FC19E5E5E4E18916609B7F8911C12","payoutInstructionUUID":"EBB5C73503084E3C9A6EB2C2B1E1E7E4","payeeAlias":"46712345678","amount":200.00,"currency":"SEK","payoutType":"PAYOUT","status":"PAID","dateCreated":"2021-03-05T10:12:04.472Z","datePaid":"2021-03-05T10:12:08.472Z"}`))
+		case "0D6C8F0A7B1E4B5DA2A1C4F3E7B9D812":
+			w.Write([]byte(`{"payoutInstructionUUID":"0D6C8F0A7B1E4B5DA2A1C4F3E7B9D812","payeeAlias":"46712345678","amount":200.00,"currency":"SEK","status":"ERROR","dateCreated":"2021-03-05T10:12:04","errorCode":"RF07","errorMessage":"Transaction declined"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`[{"errorCode":"RM01","errorMessage":"Payout not found"}]`))
+		}
+	})
+
+	paid, err := s.PayoutStatus(context.Background(), server.URL+"/swish-cpcapi/api/v1/payouts/EBB5C73503084E3C9A6EB2C2B1E1E7E4")
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", paid.Status)
+	assert.Equal(t, "EBB5C73503084E3C9A6EB2C2B1E1E7E4", paid.PayoutInstructionUUID)
+	assert.Equal(t, "46712345678", paid.PayeeAlias)
+	assert.Equal(t, 200.00, paid.Amount)
+	assert.Equal(t, time.Date(2021, 3, 5, 10, 12, 8, 472000000, time.UTC), paid.DatePaid)
+
+	failed, err := s.PayoutStatus(context.Background(), server.URL+"/swish-cpcapi/api/v1/payouts/0D6C8F0A7B1E4B5DA2A1C4F3E7B9D812")
+	assert.NoError(t, err)
+	assert.Equal(t, "ERROR", failed.Status)
+	assert.Equal(t, "RF07", failed.ErrorCode)
+	assert.True(t, failed.DatePaid.IsZero())
+
+	missing, err := s.PayoutStatus(context.Background(), server.URL+"/swish-cpcapi/api/v1/payouts/00000000000000000000000000000000")
+	assert.Error(t, err)
+	assert.Equal(t, "RM01", missing.ErrorCode)
+
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(`{"payoutInstructionUUID":"EBB5C73503084E3C9A6EB2C2B1E1E7E4","status":"PAID"}`))
+	callback, err := swish.DecodePayoutCallback(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "EBB5C73503084E3C9A6EB2C2B1E1E7E4", callback.PayoutInstructionUUID)
+	assert.Equal(t, "PAID", callback.Status)
+}
+
+func TestNew_MaxConcurrentRequests(t *testing.T) {
+	var inFlight, maxInFlight int32
+	s, server := newTestSwish(t, swish.Options{MaxConcurrentRequests: 2}, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"status":"CREATED"}`))
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Status(context.Background(), location)
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(2), maxInFlight)
+
+	block := make(chan struct{})
+	defer close(block)
+	s, server = newTestSwish(t, swish.Options{MaxConcurrentRequests: 1}, func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	})
+
+	location = server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	go s.Status(context.Background(), location)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.Status(ctx, location)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestNew_CertificateKeyMismatch(t *testing.T) {
+	cert, err := ioutil.ReadFile("certificates/Swish_Merchant_TestCertificate_1234679304.pem")
+	if err != nil {
+		t.Fatalf("could not load certificate: %s", err.Error())
+	}
+
+	block, _ := pem.Decode(cert)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("could not parse certificate: %s", err.Error())
+	}
+
+	// x/crypto/pkcs12 can not decode a p12 without a private key, so the blocks are checked directly
+	err = swish.ValidatePEMBlocks([]*pem.Block{block})
+	assert.EqualError(t, err, "p12 certificate must hold exactly one private key, found 0")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err.Error())
+	}
+
+	mismatched, err := gopkcs12.Encode(rand.Reader, key, leaf, nil, "swish")
+	if err != nil {
+		t.Fatalf("could not encode certificate: %s", err.Error())
+	}
+
+	_, err = swish.New(swish.Options{Passphrase: "swish", CA: swish.Certificate, SSLCertificate: mismatched, Test: true})
+	assert.EqualError(t, err, "private key does not match certificate")
+}
+
+func TestSwish_CreatePaymentRequest_WithPayer(t *testing.T) {
+	var body map[string]interface{}
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayeeAlias:  "1234679304",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	}
+
+	ctx := swish.WithPayer(context.Background(), "46712345678")
+	_, err := s.CreatePaymentRequest(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "46712345678", body["payerAlias"])
+
+	request.PayerAlias = "46700000000"
+	_, err = s.CreatePaymentRequest(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "46700000000", body["payerAlias"])
+
+	request.PayerAlias = ""
+	body = nil
+	_, err = s.CreatePaymentRequest(swish.WithPayer(context.Background(), "0712-345678"), request)
+	assert.Error(t, err)
+	assert.Nil(t, body)
+}
+
+func TestSwish_CreatePaymentRequestWithExpiry(t *testing.T) {
+	cancelled := make(chan string, 2)
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+path.Base(r.URL.Path))
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			w.Write([]byte(`{"status":"CREATED"}`))
+		case http.MethodPatch:
+			cancelled <- path.Base(r.URL.Path)
+			w.Write([]byte(`{"status":"CANCELLED"}`))
+		}
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	_, stop, err := s.CreatePaymentRequestWithExpiry(context.Background(), request, 10*time.Millisecond)
+	assert.NoError(t, err)
+	defer stop()
+
+	select {
+	case id := <-cancelled:
+		assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", id)
+	case <-time.After(time.Second):
+		t.Fatal("payment request was not cancelled")
+	}
+
+	request.InstructionUUID = "D2EB91F4F3A74088970FA108B58BF8D9"
+	_, stop, err = s.CreatePaymentRequestWithExpiry(context.Background(), request, 50*time.Millisecond)
+	assert.NoError(t, err)
+	stop()
+	stop()
+
+	select {
+	case id := <-cancelled:
+		t.Fatalf("stopped payment request %s was cancelled", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestVerifyCallbackIdentifier(t *testing.T) {
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID"}`))
+	r.Header.Set("callbackIdentifier", "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B")
+
+	callback, err := swish.DecodeCallback(r)
+	assert.NoError(t, err)
+	assert.True(t, swish.VerifyCallbackIdentifier(callback, "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B"))
+	assert.False(t, swish.VerifyCallbackIdentifier(callback, "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9C"))
+	assert.False(t, swish.VerifyCallbackIdentifier(callback, ""))
+	assert.False(t, swish.VerifyCallbackIdentifier(swish.CallbackPayload{}, ""))
+}
+
+func TestSwish_ValidateLocation(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"PAID"}`))
+	})
+
+	assert.NoError(t, s.ValidateLocation(server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"))
+	assert.NoError(t, s.ValidateLocation(server.URL+"/swish-cpcapi/api/v1/refunds/d2eb91f4-f3a7-4088-970f-a108b58bf8d9"))
+	assert.Error(t, s.ValidateLocation(server.URL+"/swish-cpcapi/api/v1/paymentrequests/unknown"))
+	assert.Error(t, s.ValidateLocation(server.URL+"/swish-cpcapi/api/v1/accounts/11A86BE70EA346E4B1C39C874173F088"))
+	assert.Error(t, s.ValidateLocation("https://169.254.169.254/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"))
+
+	_, err := s.Status(context.Background(), "https://169.254.169.254/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+}
+
+func TestSwish_Status_LocationCheck(t *testing.T) {
+	attacker := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"PAID"}`))
+	}))
+	defer attacker.Close()
+
+	location := attacker.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+	_, err := s.Status(context.Background(), location)
+	assert.Error(t, err)
+	_, err = s.CancelPayment(context.Background(), location)
+	assert.Error(t, err)
+
+	s, _ = newTestSwish(t, swish.Options{LocationHosts: []string{strings.TrimPrefix(attacker.URL, "https://")}}, nil)
+	status, err := s.Status(context.Background(), location)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+
+	s, _ = newTestSwish(t, swish.Options{DisableLocationCheck: true}, nil)
+	status, err = s.Status(context.Background(), attacker.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+}
+
+func TestSwish_Status_RedirectCheck(t *testing.T) {
+	var attacked int
+	attacker := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attacked++
+		w.Write([]byte(`{"status":"PAID"}`))
+	}))
+	defer attacker.Close()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch path.Base(r.URL.Path) {
+		case "11A86BE70EA346E4B1C39C874173F088":
+			http.Redirect(w, r, attacker.URL+"/swish-cpcapi/api/v1/paymentrequests/6D6CD7406ECE4542A80152D909EF9F6B", http.StatusFound)
+		case "D2EB91F4F3A74088970FA108B58BF8D9":
+			http.Redirect(w, r, "/swish-cpcapi/api/v1/paymentrequests/6D6CD7406ECE4542A80152D909EF9F6B", http.StatusFound)
+		default:
+			w.Write([]byte(`{"id":"6D6CD7406ECE4542A80152D909EF9F6B","status":"CREATED"}`))
+		}
+	}
+
+	s, server := newTestSwish(t, swish.Options{}, handler)
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/"
+	_, err := s.Status(context.Background(), location+"11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not at the endpoint")
+	assert.Equal(t, 0, attacked)
+
+	status, err := s.Status(context.Background(), location+"D2EB91F4F3A74088970FA108B58BF8D9")
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATED", status.Status)
+
+	s, server = newTestSwish(t, swish.Options{LocationHosts: []string{strings.TrimPrefix(attacker.URL, "https://")}}, handler)
+	status, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, 1, attacked)
+}
+
+func TestSwish_StatusBatch(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		if id == "D2EB91F4F3A74088970FA108B58BF8D9" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`[{"errorCode":"RP04","errorMessage":"No payment request found related to a token"}]`))
+			return
+		}
+
+		fmt.Fprintf(w, `{"id":%q,"status":"PAID"}`, id)
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/"
+	results := s.StatusBatch(context.Background(), []string{
+		location + "11A86BE70EA346E4B1C39C874173F088",
+		location + "D2EB91F4F3A74088970FA108B58BF8D9",
+		location + "6D6CD7406ECE4542A80152D909EF9F6B",
+		"https://localhost/swish-cpcapi/api/v1/paymentrequests/6D6CD7406ECE4542A80152D909EF9F6B",
+	}, 2)
+
+	assert.Len(t, results, 4)
+
+	successes := results.Successes()
+	assert.Len(t, successes, 2)
+	assert.Equal(t, 0, successes[0].Index)
+	assert.Equal(t, "11A86BE70EA346E4B1C39C874173F088", successes[0].Status.InstructionUUID)
+	assert.Equal(t, 2, successes[1].Index)
+	assert.Equal(t, "6D6CD7406ECE4542A80152D909EF9F6B", successes[1].Status.InstructionUUID)
+
+	failures := results.Failures()
+	assert.Len(t, failures, 2)
+	assert.Equal(t, 1, failures[0].Index)
+	assert.Equal(t, "RP04", failures[0].Status.ErrorCode)
+	assert.Equal(t, 3, failures[1].Index)
+	assert.Error(t, failures[1].Err)
+}
+
+func TestNew_DebugCurl(t *testing.T) {
+	var logs bytes.Buffer
+	s, server := newTestSwish(t, swish.Options{DebugCurl: true, Language: "sv", Logger: log.New(&logs, "", 0)}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"BE18","errorMessage":"Payer alias is invalid"}]`))
+	})
+
+	_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		InstructionUUID:    "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:        "https://localhost:8080/callback",
+		PayeeAlias:         "1234679304",
+		PayerAlias:         "4671234768",
+		PayerSSN:           "199001011234",
+		Amount:             "100.01",
+		Currency:           "SEK",
+		CallbackIdentifier: "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B",
+	})
+	assert.Error(t, err)
+
+	output := logs.String()
+	assert.Contains(t, output, "curl -X PUT --cert client.pem --key client.key -H 'Accept: application/json' -H 'Accept-Encoding: gzip' -H 'Accept-Language: sv' -H 'Content-Type: application/json'")
+	assert.Contains(t, output, `"payerSSN":"REDACTED"`)
+	assert.Contains(t, output, `"callbackIdentifier":"REDACTED"`)
+	assert.Contains(t, output, `"payerAlias":"•••• 4768"`)
+	assert.NotContains(t, output, "4671234768")
+	assert.NotContains(t, output, "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B")
+	assert.Contains(t, output, "'"+server.URL+"/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088'")
+	assert.NotContains(t, output, "199001011234")
+}
+
+func TestCallbackPayload_Matches(t *testing.T) {
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(`{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID","amount":100.1}`))
+	callback, err := swish.DecodeCallback(r)
+	assert.NoError(t, err)
+
+	assert.True(t, callback.AmountMatches("100.10"))
+	assert.True(t, callback.AmountMatches("100.1"))
+	assert.False(t, callback.AmountMatches("100.11"))
+	assert.False(t, callback.AmountMatches("10.01"))
+	assert.False(t, callback.AmountMatches("invalid"))
+
+	assert.True(t, callback.StatusMatches(swish.StatusPaid))
+	assert.False(t, callback.StatusMatches(swish.StatusCreated))
+}
+
+func TestSwish_EndpointHeaders(t *testing.T) {
+	type request struct{ method, path, contentType, accept string }
+	var got request
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		got = request{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Accept")}
+		switch r.Method {
+		case http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPost:
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		default:
+			w.Write([]byte(`{"status":"CREATED"}`))
+		}
+	})
+
+	s.QRURL = server.URL + "/qrg-swish"
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+
+	for _, tc := range []struct {
+		call     func() error
+		expected request
+	}{
+		{
+			call: func() error {
+				_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+					InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+					CallbackURL:     "https://localhost:8080/callback",
+					PayeeAlias:      "1234679304",
+					Amount:          "100.01",
+					Currency:        "SEK",
+				})
+				return err
+			},
+			expected: request{"PUT", "/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088", "application/json", "application/json"},
+		},
+		{
+			call: func() error {
+				_, err := s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+					InstructionUUID:          "D2EB91F4F3A74088970FA108B58BF8D9",
+					OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+					CallbackURL:              "https://localhost:8080/callback",
+					Amount:                   "100.01",
+					Currency:                 "SEK",
+				})
+				return err
+			},
+			expected: request{"PUT", "/swish-cpcapi/api/v2/refunds/D2EB91F4F3A74088970FA108B58BF8D9", "application/json", "application/json"},
+		},
+		{
+			call: func() error {
+				_, err := s.Status(context.Background(), location)
+				return err
+			},
+			expected: request{"GET", "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", "", "application/json"},
+		},
+		{
+			call: func() error {
+				_, err := s.CancelPayment(context.Background(), location)
+				return err
+			},
+			expected: request{"PATCH", "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", "application/json-patch+json", "application/json"},
+		},
+		{
+			call: func() error {
+				_, err := s.GeneratePayeeQR(context.Background(), swish.PayeeQROptions{Payee: "1234679304"})
+				return err
+			},
+			expected: request{"POST", "/qrg-swish/api/v1/prefilled", "application/json", ""},
+		},
+	} {
+		got = request{}
+		assert.NoError(t, tc.call())
+		assert.Equal(t, tc.expected, got)
+	}
+}
+
+func TestSwish_Status_UnexpectedResponse(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	_, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+}
+
+func TestSwish_TimeUntilExpiry(t *testing.T) {
+	created := time.Date(2021, 3, 5, 10, 0, 0, 0, time.UTC)
+	now := created
+	s, _ := newTestSwish(t, swish.Options{}, nil)
+	swish.SetNow(s, func() time.Time { return now })
+
+	assert.Equal(t, 3*time.Minute, s.TimeUntilExpiry(created))
+	assert.False(t, s.IsLikelyExpired(created))
+
+	now = created.Add(3*time.Minute - time.Second)
+	assert.Equal(t, time.Second, s.TimeUntilExpiry(created))
+	assert.False(t, s.IsLikelyExpired(created))
+
+	now = created.Add(3 * time.Minute)
+	assert.Equal(t, time.Duration(0), s.TimeUntilExpiry(created))
+	assert.True(t, s.IsLikelyExpired(created))
+
+	now = created.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), s.TimeUntilExpiry(created))
+
+	s, _ = newTestSwish(t, swish.Options{TokenValidity: 5 * time.Minute}, nil)
+	swish.SetNow(s, func() time.Time { return created.Add(4 * time.Minute) })
+	assert.Equal(t, time.Minute, s.TimeUntilExpiry(created))
+	assert.False(t, s.IsLikelyExpired(created))
+}
+
+func TestNew_ErrorFormatter(t *testing.T) {
+	formatter := func(errs []swish.ErrorResponse) string {
+		var codes []string
+		for _, e := range errs {
+			codes = append(codes, e.ErrorCode)
+		}
+
+		return "Betalningen kunde inte skapas (" + strings.Join(codes, ", ") + ")"
+	}
+
+	s, _ := newTestSwish(t, swish.Options{ErrorFormatter: formatter}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"FF08","errorMessage":"PayeePaymentReference is invalid"},{"errorCode":"BE18","errorMessage":"Payer alias is invalid"}]`))
+	})
+
+	result, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayeeAlias:  "1234679304",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	})
+	assert.EqualError(t, err, "Betalningen kunde inte skapas (FF08, BE18)")
+	assert.Len(t, result.ErrorCodes, 2)
+	assert.Equal(t, "PayeePaymentReference is invalid", result.ErrorCodes[0].ErrorMessage)
+}
+
+func TestSwish_CreatePaymentRequestRaw(t *testing.T) {
+	body := []byte(`{"callbackUrl":"https://localhost:8080/callback","payeeAlias":"1234679304","amount":"100.01","currency":"SEK"}`)
+	var received []byte
+	var requestPath string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+		requestPath = r.URL.Path
+		if bytes.Contains(received, []byte(`"amount":"0"`)) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`[{"errorCode":"PA02","errorMessage":"Amount value is missing or not a valid number"}]`))
+			return
+		}
+
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+		w.Header().Set("PaymentRequestToken", "f34DS34lfd0d03fdDselkfd3ffk21")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	result, err := s.CreatePaymentRequestRaw(context.Background(), "11a86be7-0ea3-46e4-b1c3-9c874173f088", body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, received)
+	assert.Equal(t, "/swish-cpcapi/api/v2/paymentrequests/11A86BE70EA346E4B1C39C874173F088", requestPath)
+	assert.Equal(t, server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", result.Location)
+	assert.Equal(t, "f34DS34lfd0d03fdDselkfd3ffk21", result.PaymentRequestToken)
+
+	result, err = s.CreatePaymentRequestRaw(context.Background(), "11A86BE70EA346E4B1C39C874173F088", []byte(`{"amount":"0"}`))
+	assert.EqualError(t, err, "[PA02] Amount value is missing or not a valid number")
+	assert.Equal(t, "PA02", result.ErrorCodes[0].ErrorCode)
+
+	received = nil
+	_, err = s.CreatePaymentRequestRaw(context.Background(), "11A86BE70EA346E4B1C39C874173F088", []byte(`{"amount":`))
+	assert.Error(t, err)
+	assert.Nil(t, received)
+}
+
+func TestSwish_Status_DeclineReason(t *testing.T) {
+	var body string
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	for _, tc := range []struct {
+		body     string
+		expected swish.DeclineReason
+	}{
+		{body: `{"status":"DECLINED","errorCode":"VR01","errorMessage":"Payer does not meet age limit"}`, expected: swish.ReasonAgeLimit},
+		{body: `{"status":"DECLINED","errorCode":"VR02"}`, expected: swish.ReasonSSNMismatch},
+		{body: `{"status":"DECLINED"}`, expected: swish.ReasonUnknown},
+		{body: `{"status":"ERROR","errorCode":"VR01"}`, expected: swish.ReasonUnknown},
+	} {
+		body = tc.body
+		status, err := s.Status(context.Background(), location)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, status.DeclineReason, tc.body)
+	}
+}
+
+func TestSwish_WaitForFinalStatusFunc(t *testing.T) {
+	var polls int32
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&polls, 1) {
+		case 1, 2:
+			w.Write([]byte(`{"status":"CREATED"}`))
+		case 3, 4:
+			w.Write([]byte(`{"status":"CREATED","payerAlias":"46712345678"}`))
+		default:
+			w.Write([]byte(`{"status":"PAID","payerAlias":"46712345678","paymentReference":"1E2FC19E5E5E4E18916609B7F8911C12"}`))
+		}
+	})
+
+	var updates []swish.StatusResponse
+	status, err := s.WaitForFinalStatusFunc(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088", time.Millisecond, func(status swish.StatusResponse) {
+		updates = append(updates, status)
+		panic("recovered")
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.Equal(t, int32(5), polls)
+	if assert.Len(t, updates, 3) {
+		assert.Equal(t, "CREATED", updates[0].Status)
+		assert.Equal(t, "46712345678", updates[1].PayerAlias)
+		assert.Equal(t, "PAID", updates[2].Status)
+	}
+}
+
+func TestNew_TestServerCertificateDiagnostics(t *testing.T) {
+	var logs bytes.Buffer
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"PAID"}`))
+	}
+
+	// The certificate of the test server is self-signed and does not chain to the Swish test CA
+	s, server := newTestSwish(t, swish.Options{CA: swish.Certificate, Logger: log.New(&logs, "", 0)}, handler)
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	_, err := s.Status(context.Background(), location)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate signed by unknown authority")
+
+	output := logs.String()
+	assert.Contains(t, output, "server certificate does not chain to the CA: x509: certificate signed by unknown authority")
+	assert.Contains(t, output, `presented chain: "O=Acme Co" issued by "O=Acme Co"`)
+	assert.Contains(t, output, "expected CA: ")
+
+	// Without a logger the handshake verifies the certificate
+	s, server = newTestSwish(t, swish.Options{CA: swish.Certificate}, handler)
+	_, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.Error(t, err)
+
+	// The certificate is accepted once it chains to the CA
+	logs.Reset()
+	s, server = newTestSwish(t, swish.Options{Logger: log.New(&logs, "", 0)}, handler)
+	status, err := s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+	assert.NotContains(t, logs.String(), "does not chain")
+
+	s, server = newTestSwish(t, swish.Options{CA: swish.Certificate, InsecureSkipVerify: true}, handler)
+	status, err = s.Status(context.Background(), server.URL+"/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088")
+	assert.NoError(t, err)
+	assert.Equal(t, "PAID", status.Status)
+}
+
+func TestNewFromEnv(t *testing.T) {
+	for _, key := range []string{"SWISH_CERT_PATH", "SWISH_PASSPHRASE", "SWISH_CA_PATH", "SWISH_TEST", "SWISH_TIMEOUT"} {
+		if value, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, value)
+		} else {
+			defer os.Unsetenv(key)
+		}
+
+		os.Unsetenv(key)
+	}
+
+	_, err := swish.NewFromEnv()
+	assert.EqualError(t, err, "missing environment variable SWISH_CERT_PATH")
+
+	os.Setenv("SWISH_CERT_PATH", "certificates/Swish_Merchant_TestCertificate_1234679304.p12")
+	_, err = swish.NewFromEnv()
+	assert.EqualError(t, err, "missing environment variable SWISH_PASSPHRASE")
+
+	os.Setenv("SWISH_PASSPHRASE", "swish")
+	os.Setenv("SWISH_CA_PATH", "certificates/Swish_TLS_RootCA.pem")
+	os.Setenv("SWISH_TEST", "true")
+	os.Setenv("SWISH_TIMEOUT", "5")
+	s, err := swish.NewFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mss.cpc.getswish.net", s.URL)
+
+	os.Setenv("SWISH_TIMEOUT", "five")
+	_, err = swish.NewFromEnv()
+	assert.Error(t, err)
+}
+
+func TestSwish_ErrorTypes(t *testing.T) {
+	s, server := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"PA02","errorMessage":"Amount value is missing or not a valid number"}]`))
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	_, err := s.CreatePaymentRequest(context.Background(), request)
+	var swishErr *swish.Error
+	if assert.True(t, errors.As(err, &swishErr)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, swishErr.StatusCode)
+		assert.Equal(t, "PA02", swishErr.Codes[0].ErrorCode)
+	}
+
+	var networkErr *swish.NetworkError
+	assert.False(t, errors.As(err, &networkErr))
+
+	location := server.URL + "/swish-cpcapi/api/v1/paymentrequests/11A86BE70EA346E4B1C39C874173F088"
+	_, err = s.Status(context.Background(), location)
+	var httpErr *swish.HTTPError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+	}
+
+	// Nothing listens on the address of a closed server, so dialing it fails
+	server.Close()
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.True(t, errors.As(err, &networkErr))
+	assert.False(t, errors.As(err, &swishErr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Status(ctx, location)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.As(err, &networkErr))
+}
+
+func TestDeriveRefundUUID(t *testing.T) {
+	id := swish.DeriveRefundUUID("6D6CD7406ECE4542A80152D909EF9F6B", 1)
+	assert.Regexp(t, "^[0-9A-F]{32}$", id)
+	assert.Equal(t, id, swish.DeriveRefundUUID("6D6CD7406ECE4542A80152D909EF9F6B", 1))
+	assert.Equal(t, id, swish.DeriveRefundUUID("6d6cd7406ece4542a80152d909ef9f6b", 1))
+	assert.NotEqual(t, id, swish.DeriveRefundUUID("6D6CD7406ECE4542A80152D909EF9F6B", 2))
+	assert.NotEqual(t, id, swish.DeriveRefundUUID("11A86BE70EA346E4B1C39C874173F088", 1))
+}
+
+func TestStatusResponse_CompareToRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/callback", strings.NewReader(`{"payeeAlias":"1234679304","amount":100.1,"currency":"SEK","message":"Kingston USB Flash Drive 8 GB and a very long","payeePaymentReference":"0123456789"}`))
+	status, err := swish.DecodeCallback(r)
+	assert.NoError(t, err)
+
+	sent := swish.CreatePaymentRequestOptions{
+		PayeeAlias:            "1234679304",
+		Amount:                "100.10",
+		Currency:              "SEK",
+		Message:               "Kingston USB Flash Drive 8 GB and a very long message",
+		PayeePaymentReference: "0123456789",
+	}
+
+	assert.Equal(t, map[string]bool{
+		"amount":                true,
+		"currency":              true,
+		"payeeAlias":            true,
+		"message":               false,
+		"payeePaymentReference": true,
+	}, status.CompareToRequest(sent))
+}
+
+func TestSwish_CreatePaymentRequest_RetryableErrorCodes(t *testing.T) {
+	var attempts int
+	var ids []string
+	code := "FF10"
+	s, _ := newTestSwish(t, swish.Options{RetryableErrorCodes: []string{"FF10"}, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		ids = append(ids, path.Base(r.URL.Path))
+		if attempts == 1 || code != "FF10" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, `[{"errorCode":%q,"errorMessage":"Error"}]`, code)
+			return
+		}
+
+		w.Header().Set("Location", "https://"+r.Host+"/swish-cpcapi/api/v1/paymentrequests/"+path.Base(r.URL.Path))
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	request := swish.CreatePaymentRequestOptions{
+		InstructionUUID: "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:     "https://localhost:8080/callback",
+		PayeeAlias:      "1234679304",
+		Amount:          "100.01",
+		Currency:        "SEK",
+	}
+
+	result, err := s.CreatePaymentRequest(context.Background(), request)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Location)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []string{"11A86BE70EA346E4B1C39C874173F088", "11A86BE70EA346E4B1C39C874173F088"}, ids)
+
+	attempts = 0
+	code = "PA02"
+	_, err = s.CreatePaymentRequest(context.Background(), request)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestSwish_CreatePaymentRequest_RetryableErrorCodesV1(t *testing.T) {
+	var attempts int
+	s, _ := newTestSwish(t, swish.Options{APIVersion: swish.V1, RetryableErrorCodes: []string{"FF10"}, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"FF10","errorMessage":"Bank system processing error"}]`))
+	})
+
+	_, err := s.CreatePaymentRequest(context.Background(), swish.CreatePaymentRequestOptions{
+		CallbackURL: "https://localhost:8080/callback",
+		PayeeAlias:  "1234679304",
+		Amount:      "100.01",
+		Currency:    "SEK",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	attempts = 0
+	_, err = s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		PayerAlias:               "1234679304",
+		Amount:                   "100.01",
+		Currency:                 "SEK",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestCallbackHandler_Timeout(t *testing.T) {
+	body := `{"id":"11A86BE70EA346E4B1C39C874173F088","status":"PAID"}`
+	slow := func(cancelled chan<- bool, release <-chan struct{}) func(context.Context, swish.CallbackPayload) error {
+		return func(ctx context.Context, payload swish.CallbackPayload) error {
+			select {
+			case <-ctx.Done():
+				cancelled <- true
+			case <-release:
+				cancelled <- false
+			}
+			return nil
+		}
+	}
+
+	cancelled := make(chan bool, 1)
+	release := make(chan struct{})
+	handler := swish.CallbackHandler(slow(cancelled, release), swish.CallbackHandlerOptions{Timeout: 20 * time.Millisecond})
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/callback", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	close(release)
+	assert.False(t, <-cancelled)
+
+	cancelled = make(chan bool, 1)
+	handler = swish.CallbackHandler(slow(cancelled, make(chan struct{})), swish.CallbackHandlerOptions{
+		Timeout:         20 * time.Millisecond,
+		CancelOnTimeout: true,
+	})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/callback", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, <-cancelled)
+
+	handler = swish.CallbackHandler(func(ctx context.Context, payload swish.CallbackPayload) error {
+		assert.Equal(t, "PAID", payload.Status)
+		return errors.New("database unavailable")
+	}, swish.CallbackHandlerOptions{Timeout: time.Second})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/callback", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	handler = swish.CallbackHandler(func(ctx context.Context, payload swish.CallbackPayload) error {
+		return nil
+	}, swish.CallbackHandlerOptions{})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/callback", strings.NewReader(`{`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreatePaymentRequestOptions_ToQuery(t *testing.T) {
+	opts := swish.CreatePaymentRequestOptions{
+		InstructionUUID:       "11A86BE70EA346E4B1C39C874173F088",
+		CallbackURL:           "https://example.com/callback?order=1",
+		PayeeAlias:            "1234679304",
+		Amount:                "100.01",
+		Currency:              "SEK",
+		PayeePaymentReference: "order-1",
+		PayerAlias:            "46712345678",
+		PayerSSN:              "197001019876",
+		PayerAgeLimit:         "18",
+		Message:               "Kaffe & bulle",
+		CallbackIdentifier:    "F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B",
+	}
+
+	query := opts.ToQuery()
+	assert.Empty(t, query.Get("payerAlias"))
+	assert.Empty(t, query.Get("payerSSN"))
+	assert.Empty(t, query.Get("callbackIdentifier"))
+	assert.NotContains(t, query.Encode(), "11A86BE70EA346E4B1C39C874173F088")
+
+	parsed, err := url.ParseQuery(query.Encode())
+	assert.NoError(t, err)
+
+	result, err := swish.PaymentOptionsFromQuery(parsed)
+	assert.NoError(t, err)
+
+	expected := opts
+	expected.InstructionUUID = ""
+	expected.PayerAlias = ""
+	expected.PayerSSN = ""
+	expected.CallbackIdentifier = ""
+	assert.Equal(t, expected, result)
+
+	_, err = swish.PaymentOptionsFromQuery(url.Values{"payerSSN": {"197001019876"}})
+	assert.Error(t, err)
+
+	_, err = swish.PaymentOptionsFromQuery(url.Values{"amount": {"1", "2"}})
+	assert.Error(t, err)
+
+	_, err = swish.PaymentOptionsFromQuery(url.Values{"colour": {"blue"}})
+	assert.Error(t, err)
+}
+
+func TestSwish_CreateRefund_ExceedsRemaining(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`[{"errorCode":"RF08","errorMessage":"Amount value is too large, or amount exceeds the amount of the original payment minus any previous refunds"}]`))
+	})
+
+	_, err := s.CreateRefund(context.Background(), swish.CreateRefundOptions{
+		InstructionUUID:          "D2EB91F4F3A74088970FA108B58BF8D9",
+		OriginalPaymentReference: "6D6CD7406ECE4542A80152D909EF9F6B",
+		CallbackURL:              "https://localhost:8080/callback",
+		PayerAlias:               "1234679304",
+		Amount:                   "150.00",
+		Currency:                 "SEK",
+	})
+	assert.True(t, errors.Is(err, swish.ErrRefundExceedsRemaining))
+	assert.Contains(t, err.Error(), "RF08")
+
+	var limitErr *swish.RefundLimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "150.00", limitErr.Attempted)
+	assert.Equal(t, "6D6CD7406ECE4542A80152D909EF9F6B", limitErr.OriginalPaymentReference)
+
+	var swishErr *swish.Error
+	assert.True(t, errors.As(err, &swishErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, swishErr.StatusCode)
+	assert.Equal(t, "RF08", swishErr.Codes[0].ErrorCode)
+}
+
+func TestSwish_RetryJitter(t *testing.T) {
+	s, _ := newTestSwish(t, swish.Options{RetryJitter: true}, func(w http.ResponseWriter, r *http.Request) {})
+	swish.SetJitterRand(s, mathrand.New(mathrand.NewSource(1)))
+
+	backoff := 100 * time.Millisecond
+	var delays []time.Duration
+	for i := 0; i < 20; i++ {
+		delay := swish.RetryDelay(s, backoff)
+		assert.True(t, delay >= 0 && delay < backoff, "delay %s is not within [0, %s)", delay, backoff)
+		delays = append(delays, delay)
+	}
+	assert.NotEqual(t, delays[0], delays[1])
+
+	swish.SetJitterRand(s, mathrand.New(mathrand.NewSource(1)))
+	assert.Equal(t, delays[0], swish.RetryDelay(s, backoff))
+
+	s, _ = newTestSwish(t, swish.Options{}, func(w http.ResponseWriter, r *http.Request) {})
+	assert.Equal(t, backoff, swish.RetryDelay(s, backoff))
+}
+
+func TestStatusResponse_ToCreateOptions(t *testing.T) {
+	var status swish.StatusResponse
+	err := json.Unmarshal([]byte(`{"id":"11A86BE70EA346E4B1C39C874173F088","payeePaymentReference":"order-1","paymentReference":"1E2FC19E5E5E4E18916609B7F8911C12","callbackUrl":"https://example.com/callback","payerAlias":"46712345678","payeeAlias":"1234679304","amount":100.1,"currency":"SEK","message":"Kaffe","status":"CANCELLED","callbackIdentifier":"F4A9E1C24B7D4E2A9C0B6E1D3F5A7C9B"}`), &status)
+	assert.NoError(t, err)
+
+	assert.Equal(t, swish.CreatePaymentRequestOptions{
+		CallbackURL:           "https://example.com/callback",
+		PayeeAlias:            "1234679304",
+		Amount:                "100.10",
+		Currency:              "SEK",
+		PayeePaymentReference: "order-1",
+		Message:               "Kaffe",
+	}, status.ToCreateOptions())
+}
@@ -0,0 +1,92 @@
+package swish
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// timeLayouts are the timestamp formats that Swish has been seen to use, with and without fractional seconds and
+// time zone. Timestamps without a time zone are in UTC.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseSwishTime parses a Swish timestamp in any of the known layouts, an empty timestamp is the zero time
+func parseSwishTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time format %q", value)
+}
+
+// unmarshalWithSwishTimes decodes data into v, which must not implement json.Unmarshaler, except for the dateCreated
+// and datePaid timestamps that are parsed with parseSwishTime into dateCreated and datePaid
+func unmarshalWithSwishTimes(data []byte, v interface{}, dateCreated, datePaid *time.Time) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]string)
+	for _, name := range []string{"dateCreated", "datePaid"} {
+		if raw, ok := fields[name]; ok {
+			var value *string
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			if value != nil {
+				values[name] = *value
+			}
+
+			delete(fields, name)
+		}
+	}
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(rest, v)
+	if err != nil {
+		return err
+	}
+
+	*dateCreated, err = parseSwishTime(values["dateCreated"])
+	if err != nil {
+		return fmt.Errorf("dateCreated: %w", err)
+	}
+
+	*datePaid, err = parseSwishTime(values["datePaid"])
+	if err != nil {
+		return fmt.Errorf("datePaid: %w", err)
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes a status and accepts all known Swish timestamp layouts in DateCreated and DatePaid
+func (r *statusResponse) UnmarshalJSON(data []byte) (err error) {
+	type plain statusResponse
+	err = unmarshalWithSwishTimes(data, (*plain)(r), &r.DateCreated, &r.DatePaid)
+	if err != nil {
+		return
+	}
+
+	r.DeclineReason = declineReason(*r)
+	return
+}
@@ -0,0 +1,49 @@
+package swish
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSPolicy is a preset of the TLS versions and cipher suites that the client accepts
+type TLSPolicy int
+
+const (
+	// TLSModern accepts TLS 1.2 and later, and with TLS 1.2 only ECDHE key exchange with AES-GCM or ChaCha20-Poly1305.
+	// This is the default.
+	TLSModern TLSPolicy = iota
+
+	// TLSCompatible accepts TLS 1.2 and later with the default cipher suites of Go, which also include CBC mode
+	// suites. Use it if a proxy in front of Swish can not negotiate a modern suite.
+	TLSCompatible
+
+	// TLSStrict only accepts TLS 1.3, where every cipher suite is forward secret and authenticated
+	TLSStrict
+)
+
+// modernCipherSuites are the TLS 1.2 cipher suites of TLSModern, TLS 1.3 suites are not configurable
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// apply sets the minimum version and cipher suites of the policy on config
+func (p TLSPolicy) apply(config *tls.Config) error {
+	switch p {
+	case TLSModern:
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = modernCipherSuites
+	case TLSCompatible:
+		config.MinVersion = tls.VersionTLS12
+	case TLSStrict:
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("unknown TLS policy %d", p)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package swish
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// gzipTransport asks for gzip compressed responses and decompresses them. The http.Transport only does this by itself
+// when it added the Accept-Encoding header, which proxies and wrapping transports can break, so it is done here for
+// every response that has Content-Encoding gzip and a body.
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.Header.Get("Content-Encoding") != "gzip" || resp.ContentLength == 0 {
+		return resp, err
+	}
+
+	// An empty body, e.g. of a 201, is sent with Content-Encoding gzip without being a gzip stream
+	buffered := bufio.NewReader(resp.Body)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		resp.Body = &gzipBody{body: resp.Body}
+		return resp, nil
+	}
+
+	body, err := gzip.NewReader(buffered)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	resp.Body = &gzipBody{Reader: body, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// gzipBody reads a decompressed body and closes the underlying body. Without a Reader the body is empty.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.Reader == nil {
+		return 0, io.EOF
+	}
+
+	return b.Reader.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	if b.Reader != nil {
+		b.Reader.Close()
+	}
+
+	return b.body.Close()
+}
+
+// headerTransport adds headers to every request that does not already set them
+type headerTransport struct {
+	next   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.header {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = values
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// limitTransport bounds the number of requests in flight. A slot is held until the response body is closed.
+type limitTransport struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case t.slots <- struct{}{}:
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+
+	resp.Body = &limitBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// limitBody releases the slot of a limitTransport once the body is closed
+type limitBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *limitBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
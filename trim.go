@@ -0,0 +1,16 @@
+package swish
+
+import "strings"
+
+// trimSpace removes leading and trailing whitespace from the references and the message, spaces within them are kept
+func (opts *CreatePaymentRequestOptions) trimSpace() {
+	opts.PayeePaymentReference = strings.TrimSpace(opts.PayeePaymentReference)
+	opts.Message = strings.TrimSpace(opts.Message)
+}
+
+// trimSpace removes leading and trailing whitespace from the references and the message, spaces within them are kept
+func (opts *CreateRefundOptions) trimSpace() {
+	opts.OriginalPaymentReference = strings.TrimSpace(opts.OriginalPaymentReference)
+	opts.PayerPaymentReference = strings.TrimSpace(opts.PayerPaymentReference)
+	opts.Message = strings.TrimSpace(opts.Message)
+}